@@ -0,0 +1,125 @@
+package tools
+
+import (
+	"context"
+	"errors"
+	"sync"
+	"time"
+)
+
+// AdaptiveController tunes the concurrency of a WorkPool with AIMD: every
+// Interval it adds one worker while latency and error rate look healthy and
+// the pool has work waiting, and cuts the limit by Backoff when the error
+// rate exceeds MaxErrorRate or the average latency grows beyond Tolerance
+// times the best latency seen so far.
+type AdaptiveController struct {
+	Min, Max     int
+	Interval     time.Duration
+	MaxErrorRate float64
+	Tolerance    float64
+	Backoff      float64
+
+	pool     *WorkPool
+	mu       sync.Mutex
+	count    int
+	errs     int
+	latency  time.Duration
+	baseline time.Duration
+	stop     chan struct{}
+	once     sync.Once
+}
+
+// NewAdaptiveController returns a controller for p keeping its concurrency
+// between min and max. Adjust the exported fields before calling Start.
+func NewAdaptiveController(p *WorkPool, min, max int) *AdaptiveController {
+	if min < 1 {
+		min = 1
+	}
+	if max < min {
+		max = min
+	}
+	return &AdaptiveController{
+		Min:          min,
+		Max:          max,
+		Interval:     2 * time.Second,
+		MaxErrorRate: 0.1,
+		Tolerance:    2,
+		Backoff:      0.75,
+		pool:         p,
+		stop:         make(chan struct{}),
+	}
+}
+
+// Start hooks the controller into the pool and adjusts it until Stop is
+// called or the pool's run ends. The pool starts at Min workers.
+func (c *AdaptiveController) Start() {
+	c.pool.OnResult(c.observe)
+	c.pool.Resize(c.Min)
+	go func() {
+		ticker := time.NewTicker(c.Interval)
+		defer ticker.Stop()
+		for {
+			select {
+			case <-ticker.C:
+				c.adjust()
+			case <-c.stop:
+				return
+			case <-c.pool.Context().Done():
+				return
+			}
+		}
+	}()
+}
+
+// Stop freezes the pool at its current concurrency.
+func (c *AdaptiveController) Stop() {
+	c.once.Do(func() { close(c.stop) })
+}
+
+func (c *AdaptiveController) observe(res TaskResult) {
+	if errors.Is(res.Err, context.Canceled) {
+		return
+	}
+	c.mu.Lock()
+	c.count++
+	if res.Err != nil {
+		c.errs++
+	}
+	c.latency += res.Duration
+	c.mu.Unlock()
+}
+
+func (c *AdaptiveController) adjust() {
+	c.mu.Lock()
+	count, errs, latency := c.count, c.errs, c.latency
+	c.count, c.errs, c.latency = 0, 0, 0
+	if count == 0 {
+		c.mu.Unlock()
+		return
+	}
+	avg := latency / time.Duration(count)
+	if c.baseline == 0 || avg < c.baseline {
+		c.baseline = avg
+	} else {
+		// let the baseline follow a target that got slower for good
+		c.baseline += (avg - c.baseline) / 20
+	}
+	healthy := float64(errs)/float64(count) <= c.MaxErrorRate &&
+		float64(avg) <= c.Tolerance*float64(c.baseline)
+	c.mu.Unlock()
+
+	n := c.pool.Workers()
+	switch {
+	case !healthy:
+		n = int(float64(n) * c.Backoff)
+	case c.pool.Queued() > 0:
+		n++
+	}
+	if n < c.Min {
+		n = c.Min
+	}
+	if n > c.Max {
+		n = c.Max
+	}
+	c.pool.Resize(n)
+}
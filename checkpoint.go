@@ -0,0 +1,273 @@
+package tools
+
+import (
+	"bufio"
+	"log"
+	"os"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+)
+
+// Checkpoint remembers which task keys of a job completed or failed, so an
+// interrupted run can resume where it stopped. Records are appended to a
+// log file that is compacted once it holds mostly superseded lines.
+type Checkpoint struct {
+	path string
+
+	mu      sync.Mutex
+	f       *os.File
+	w       *bufio.Writer
+	done    map[string]bool
+	failed  map[string]string // key -> last error
+	records int               // lines in the log
+	unsaved int               // records not flushed yet
+}
+
+const checkpointFlushEvery = 64
+
+// OpenCheckpoint loads the log at path, creating it if needed. A torn last
+// line left by a crash is ignored and terminated, so the next record starts
+// on a line of its own.
+func OpenCheckpoint(path string) (*Checkpoint, error) {
+	c := &Checkpoint{path: path, done: make(map[string]bool), failed: make(map[string]string)}
+	torn := false
+	if f, err := os.Open(path); err == nil {
+		scanner := bufio.NewScanner(f)
+		scanner.Buffer(nil, 1<<20)
+		for scanner.Scan() {
+			c.replay(scanner.Text())
+		}
+		err = scanner.Err()
+		if err == nil {
+			torn, err = endsTorn(f)
+		}
+		f.Close()
+		if err != nil {
+			return nil, err
+		}
+	} else if !os.IsNotExist(err) {
+		return nil, err
+	}
+	if err := c.openLog(); err != nil {
+		return nil, err
+	}
+	if torn {
+		if _, err := c.f.WriteString("\n"); err != nil {
+			c.f.Close()
+			return nil, err
+		}
+	}
+	return c, nil
+}
+
+// endsTorn reports whether f is not empty and lacks a final newline.
+func endsTorn(f *os.File) (bool, error) {
+	fi, err := f.Stat()
+	if err != nil || fi.Size() == 0 {
+		return false, err
+	}
+	last := make([]byte, 1)
+	if _, err := f.ReadAt(last, fi.Size()-1); err != nil {
+		return false, err
+	}
+	return last[0] != '\n', nil
+}
+
+func (c *Checkpoint) openLog() error {
+	f, err := os.OpenFile(c.path, os.O_WRONLY|os.O_CREATE|os.O_APPEND, 0644)
+	if err != nil {
+		return err
+	}
+	c.f, c.w = f, bufio.NewWriter(f)
+	return nil
+}
+
+// replay applies one log line: "D\t<key>" or "F\t<key>\t<error>", quoted.
+func (c *Checkpoint) replay(line string) {
+	fields := strings.Split(line, "\t")
+	if len(fields) < 2 {
+		return
+	}
+	key, err := strconv.Unquote(fields[1])
+	if err != nil {
+		return
+	}
+	switch {
+	case fields[0] == "D":
+		c.done[key] = true
+		delete(c.failed, key)
+	case fields[0] == "F" && len(fields) == 3:
+		msg, err := strconv.Unquote(fields[2])
+		if err != nil {
+			return
+		}
+		c.failed[key] = msg
+		delete(c.done, key)
+	default:
+		return
+	}
+	c.records++
+}
+
+// Done reports whether key already completed.
+func (c *Checkpoint) Done(key string) bool {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	return c.done[key]
+}
+
+// MarkDone records key as completed, clearing an earlier failure.
+func (c *Checkpoint) MarkDone(key string) error {
+	return c.append("D\t" + strconv.Quote(key))
+}
+
+// MarkFailed records that key failed with err.
+func (c *Checkpoint) MarkFailed(key string, err error) error {
+	return c.append("F\t" + strconv.Quote(key) + "\t" + strconv.Quote(err.Error()))
+}
+
+func (c *Checkpoint) append(line string) error {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	c.replay(line)
+	if _, err := c.w.WriteString(line + "\n"); err != nil {
+		return err
+	}
+	c.unsaved++
+	if c.unsaved >= checkpointFlushEvery {
+		if err := c.flushLocked(); err != nil {
+			return err
+		}
+	}
+	if live := len(c.done) + len(c.failed); c.records > 2*live+1024 {
+		return c.compactLocked()
+	}
+	return nil
+}
+
+// Failed returns the keys whose last attempt failed with their errors, for
+// a run that retries only them.
+func (c *Checkpoint) Failed() map[string]string {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	failed := make(map[string]string, len(c.failed))
+	for k, v := range c.failed {
+		failed[k] = v
+	}
+	return failed
+}
+
+// FailedKeys returns the failed keys, sorted.
+func (c *Checkpoint) FailedKeys() []string {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	keys := make([]string, 0, len(c.failed))
+	for k := range c.failed {
+		keys = append(keys, k)
+	}
+	sort.Strings(keys)
+	return keys
+}
+
+// Len returns how many keys completed.
+func (c *Checkpoint) Len() int {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	return len(c.done)
+}
+
+// Flush writes buffered records to disk.
+func (c *Checkpoint) Flush() error {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	return c.flushLocked()
+}
+
+func (c *Checkpoint) flushLocked() error {
+	c.unsaved = 0
+	if err := c.w.Flush(); err != nil {
+		return err
+	}
+	return c.f.Sync()
+}
+
+// Compact rewrites the log with one line per key.
+func (c *Checkpoint) Compact() error {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	return c.compactLocked()
+}
+
+func (c *Checkpoint) compactLocked() error {
+	if err := c.w.Flush(); err != nil {
+		return err
+	}
+	tmp := c.path + ".tmp"
+	f, err := os.OpenFile(tmp, os.O_WRONLY|os.O_CREATE|os.O_TRUNC, 0644)
+	if err != nil {
+		return err
+	}
+	w := bufio.NewWriter(f)
+	for k := range c.done {
+		w.WriteString("D\t" + strconv.Quote(k) + "\n")
+	}
+	for k, msg := range c.failed {
+		w.WriteString("F\t" + strconv.Quote(k) + "\t" + strconv.Quote(msg) + "\n")
+	}
+	if err := w.Flush(); err != nil {
+		f.Close()
+		return err
+	}
+	if err := f.Sync(); err != nil {
+		f.Close()
+		return err
+	}
+	f.Close()
+	// the log has to be closed before it can be replaced on Windows
+	c.f.Close()
+	if err := os.Rename(tmp, c.path); err != nil {
+		c.openLog()
+		return err
+	}
+	c.records = len(c.done) + len(c.failed)
+	c.unsaved = 0
+	return c.openLog()
+}
+
+// Close flushes and closes the log.
+func (c *Checkpoint) Close() error {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	if err := c.w.Flush(); err != nil {
+		c.f.Close()
+		return err
+	}
+	return c.f.Close()
+}
+
+//================================================================================
+
+// UseCheckpoint makes the pool skip tasks whose Key already completed in cp
+// and record every finished keyed task there. Cancelled tasks are not
+// recorded, so they run again on the next start.
+func (p *WorkPool) UseCheckpoint(cp *Checkpoint) {
+	p.mu.Lock()
+	p.checkpoint = cp
+	p.mu.Unlock()
+	p.OnResult(func(res TaskResult) {
+		if res.Key == "" || p.ctx.Err() != nil && res.Err != nil {
+			return
+		}
+		var err error
+		if res.Err == nil {
+			err = cp.MarkDone(res.Key)
+		} else {
+			err = cp.MarkFailed(res.Key, res.Err)
+		}
+		if err != nil {
+			log.Println("checkpoint:", err)
+		}
+	})
+}
@@ -0,0 +1,133 @@
+package tools
+
+import (
+	"context"
+	"errors"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"testing"
+)
+
+func tempCheckpoint(t *testing.T) (string, func()) {
+	dir, err := ioutil.TempDir("", "checkpoint")
+	if err != nil {
+		t.Fatal(err)
+	}
+	return filepath.Join(dir, "run.log"), func() { os.RemoveAll(dir) }
+}
+
+func TestCheckpointReplay(t *testing.T) {
+	path, cleanup := tempCheckpoint(t)
+	defer cleanup()
+	cp, err := OpenCheckpoint(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	cp.MarkFailed("a", errors.New("refused"))
+	cp.MarkDone("b")
+	cp.MarkFailed("c\td", errors.New("tab\tand\nnewline"))
+	cp.MarkDone("a")
+	if err := cp.Close(); err != nil {
+		t.Fatal(err)
+	}
+
+	cp, err = OpenCheckpoint(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer cp.Close()
+	if !cp.Done("a") || !cp.Done("b") || cp.Done("c\td") || cp.Len() != 2 {
+		t.Fatalf("done a=%v b=%v c=%v len=%d", cp.Done("a"), cp.Done("b"), cp.Done("c\td"), cp.Len())
+	}
+	if failed := cp.Failed(); len(failed) != 1 || failed["c\td"] != "tab\tand\nnewline" {
+		t.Fatalf("Failed() = %q", failed)
+	}
+}
+
+func TestCheckpointTornLine(t *testing.T) {
+	path, cleanup := tempCheckpoint(t)
+	defer cleanup()
+	if err := ioutil.WriteFile(path, []byte("D\t\"a\"\nD\t\"tor"), 0644); err != nil {
+		t.Fatal(err)
+	}
+	cp, err := OpenCheckpoint(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !cp.Done("a") || cp.Len() != 1 {
+		t.Fatalf("Done(a) = %v, Len() = %d", cp.Done("a"), cp.Len())
+	}
+	cp.MarkDone("b")
+	cp.Close()
+
+	cp, err = OpenCheckpoint(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer cp.Close()
+	if !cp.Done("a") || !cp.Done("b") || cp.Len() != 2 {
+		t.Fatalf("after reopen Done(a) = %v, Done(b) = %v, Len() = %d", cp.Done("a"), cp.Done("b"), cp.Len())
+	}
+}
+
+func TestCheckpointCompact(t *testing.T) {
+	path, cleanup := tempCheckpoint(t)
+	defer cleanup()
+	cp, err := OpenCheckpoint(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	for i := 0; i < 3000; i++ {
+		cp.MarkFailed("x", errors.New("again"))
+	}
+	cp.MarkDone("x")
+	cp.Close()
+	data, _ := ioutil.ReadFile(path)
+	if len(data) > 64*1024 {
+		t.Fatalf("log not compacted: %d bytes", len(data))
+	}
+	cp, _ = OpenCheckpoint(path)
+	defer cp.Close()
+	if !cp.Done("x") || len(cp.FailedKeys()) != 0 {
+		t.Fatalf("Done(x) = %v, failed %v", cp.Done("x"), cp.FailedKeys())
+	}
+}
+
+func TestWorkPoolCheckpointResume(t *testing.T) {
+	path, cleanup := tempCheckpoint(t)
+	defer cleanup()
+	run := func(fail string) []string {
+		cp, err := OpenCheckpoint(path)
+		if err != nil {
+			t.Fatal(err)
+		}
+		defer cp.Close()
+		p := NewWorkPool(context.Background(), 2)
+		p.UseCheckpoint(cp)
+		ran := make(chan string, 10)
+		for _, key := range []string{"a", "b", "c"} {
+			key := key
+			p.SubmitTask(&Task{Key: key, Run: func(ctx context.Context) error {
+				ran <- key
+				if key == fail {
+					return errors.New("boom")
+				}
+				return nil
+			}})
+		}
+		p.Wait()
+		close(ran)
+		var keys []string
+		for k := range ran {
+			keys = append(keys, k)
+		}
+		return keys
+	}
+	if got := run("b"); len(got) != 3 {
+		t.Fatalf("first run ran %v", got)
+	}
+	if got := run(""); len(got) != 1 || got[0] != "b" {
+		t.Fatalf("second run ran %v, want only the failed key", got)
+	}
+}
@@ -0,0 +1,207 @@
+package tools
+
+import (
+	"fmt"
+	"net"
+	"strings"
+)
+
+// AddrClass is a set of flags describing special-purpose address ranges.
+// Public is set alone for a globally routable unicast address.
+type AddrClass uint32
+
+const (
+	Public AddrClass = 1 << iota
+	Unspecified
+	Loopback
+	Private // RFC 1918 and IPv6 unique local (fc00::/7)
+	LinkLocal
+	Multicast
+	Broadcast
+	SharedAddressSpace // RFC 6598 carrier-grade NAT, 100.64.0.0/10
+	Documentation
+	Benchmarking
+	Reserved
+
+	// Bogon is every class that should never show up on the public internet.
+	Bogon = Unspecified | Loopback | Private | LinkLocal | Multicast | Broadcast |
+		SharedAddressSpace | Documentation | Benchmarking | Reserved
+)
+
+var addrClassNames = []struct {
+	class AddrClass
+	name  string
+}{
+	{Public, "public"},
+	{Unspecified, "unspecified"},
+	{Loopback, "loopback"},
+	{Private, "private"},
+	{LinkLocal, "linklocal"},
+	{Multicast, "multicast"},
+	{Broadcast, "broadcast"},
+	{SharedAddressSpace, "shared"},
+	{Documentation, "documentation"},
+	{Benchmarking, "benchmarking"},
+	{Reserved, "reserved"},
+}
+
+var addrClassRanges = []struct {
+	cidr  string
+	class AddrClass
+}{
+	{"0.0.0.0/32", Unspecified},
+	{"0.0.0.0/8", Reserved},
+	{"10.0.0.0/8", Private},
+	{"100.64.0.0/10", SharedAddressSpace},
+	{"127.0.0.0/8", Loopback},
+	{"169.254.0.0/16", LinkLocal},
+	{"172.16.0.0/12", Private},
+	{"192.0.0.0/24", Reserved},
+	{"192.0.2.0/24", Documentation},
+	{"192.88.99.0/24", Reserved},
+	{"192.168.0.0/16", Private},
+	{"198.18.0.0/15", Benchmarking},
+	{"198.51.100.0/24", Documentation},
+	{"203.0.113.0/24", Documentation},
+	{"224.0.0.0/4", Multicast},
+	{"240.0.0.0/4", Reserved},
+	{"255.255.255.255/32", Broadcast},
+	{"::/128", Unspecified},
+	{"::1/128", Loopback},
+	{"64:ff9b:1::/48", Reserved},
+	{"100::/64", Reserved},
+	{"2001::/23", Reserved},
+	{"2001:2::/48", Benchmarking},
+	{"2001:db8::/32", Documentation},
+	{"3fff::/20", Documentation},
+	{"fc00::/7", Private},
+	{"fe80::/10", LinkLocal},
+	{"ff00::/8", Multicast},
+}
+
+var addrClassNets = func() []*net.IPNet {
+	nets := make([]*net.IPNet, len(addrClassRanges))
+	for i, r := range addrClassRanges {
+		_, nets[i], _ = net.ParseCIDR(r.cidr)
+	}
+	return nets
+}()
+
+// Classify returns the special-purpose classes ip belongs to.
+// IPv4-mapped IPv6 addresses are classified as IPv4.
+func Classify(ip net.IP) AddrClass {
+	if v4 := ip.To4(); v4 != nil {
+		ip = v4
+	}
+	var c AddrClass
+	for i, n := range addrClassNets {
+		if n.Contains(ip) {
+			c |= addrClassRanges[i].class
+		}
+	}
+	if c == 0 {
+		return Public
+	}
+	return c
+}
+
+// IsBogon reports whether ip is not a globally routable unicast address.
+func IsBogon(ip net.IP) bool {
+	return Classify(ip)&Bogon != 0
+}
+
+func (c AddrClass) String() string {
+	var names []string
+	for _, n := range addrClassNames {
+		if c&n.class != 0 {
+			names = append(names, n.name)
+		}
+	}
+	return strings.Join(names, ",")
+}
+
+// ParseAddrClass parses a comma separated list of class names as written
+// in job configs, e.g. "public" or "private,loopback". rfc1918 and bogon
+// are accepted as aliases.
+func ParseAddrClass(s string) (AddrClass, error) {
+	var c AddrClass
+	for _, name := range strings.FieldsFunc(strings.ToLower(s), isTargetSeparator) {
+		switch name {
+		case "rfc1918":
+			c |= Private
+			continue
+		case "bogon":
+			c |= Bogon
+			continue
+		}
+		found := false
+		for _, n := range addrClassNames {
+			if n.name == name {
+				c |= n.class
+				found = true
+			}
+		}
+		if !found {
+			return 0, fmt.Errorf("unknown address class %q", name)
+		}
+	}
+	return c, nil
+}
+
+// ClassSet returns every address range carrying one of the classes in c,
+// handy for trimming an IPSet before iterating it. Public has no ranges of
+// its own; remove ClassSet(Bogon) from a set to keep its public part.
+func ClassSet(c AddrClass) *IPSet {
+	s := new(IPSet)
+	for i, r := range addrClassRanges {
+		if r.class&c != 0 {
+			s.AddPrefix(addrClassNets[i])
+		}
+	}
+	return s
+}
+
+//================================================================================
+
+// FilterIterator keeps the targets of it for which keep returns true.
+func FilterIterator(it Iterator, keep func(target string) bool) Iterator {
+	return &filterIterator{src: it, keep: keep}
+}
+
+type filterIterator struct {
+	src  Iterator
+	keep func(string) bool
+}
+
+func (it *filterIterator) Next() (string, bool) {
+	for {
+		s, ok := it.src.Next()
+		if !ok || it.keep(s) {
+			return s, ok
+		}
+	}
+}
+
+// OnlyClass keeps addresses having one of the classes in c, so
+// Public|Private keeps globally routable and RFC 1918 addresses alike.
+// Hostnames are passed through since they cannot be classified before they
+// are resolved.
+func OnlyClass(it Iterator, c AddrClass) Iterator {
+	return FilterIterator(it, func(target string) bool {
+		ip := net.ParseIP(target)
+		return ip == nil || Classify(ip)&c != 0
+	})
+}
+
+// ExcludeClass drops addresses having one of the classes in c.
+func ExcludeClass(it Iterator, c AddrClass) Iterator {
+	return FilterIterator(it, func(target string) bool {
+		ip := net.ParseIP(target)
+		return ip == nil || Classify(ip)&c == 0
+	})
+}
+
+// PublicOnly drops every bogon address.
+func PublicOnly(it Iterator) Iterator {
+	return OnlyClass(it, Public)
+}
@@ -0,0 +1,58 @@
+package tools
+
+import (
+	"net"
+	"reflect"
+	"testing"
+)
+
+func TestClassify(t *testing.T) {
+	for _, tc := range []struct {
+		ip   string
+		want AddrClass
+	}{
+		{"8.8.8.8", Public},
+		{"2606:4700::1111", Public},
+		{"10.1.1.1", Private},
+		{"::ffff:192.168.1.1", Private},
+		{"0.0.0.0", Unspecified | Reserved},
+		{"100.64.1.1", SharedAddressSpace},
+		{"fe80::1", LinkLocal},
+		{"2001:db8::1", Documentation},
+	} {
+		if got := Classify(net.ParseIP(tc.ip)); got != tc.want {
+			t.Errorf("Classify(%s) = %v, want %v", tc.ip, got, tc.want)
+		}
+	}
+}
+
+func TestParseAddrClassMixed(t *testing.T) {
+	c, err := ParseAddrClass("public, rfc1918")
+	if err != nil || c != Public|Private || c.String() != "public,private" {
+		t.Fatalf("ParseAddrClass() = %v, %v", c, err)
+	}
+	if _, err := ParseAddrClass("public,nowhere"); err == nil {
+		t.Fatal("ParseAddrClass accepted an unknown class")
+	}
+
+	targets := []string{"10.0.0.1", "8.8.8.8", "example.com", "192.0.2.1", "127.0.0.1"}
+	for _, tc := range []struct {
+		classes string
+		want    []string
+	}{
+		{"public", []string{"8.8.8.8", "example.com"}},
+		{"public,private", []string{"10.0.0.1", "8.8.8.8", "example.com"}},
+		{"private,loopback", []string{"10.0.0.1", "example.com", "127.0.0.1"}},
+	} {
+		c, err := ParseAddrClass(tc.classes)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if got := Collect(OnlyClass(SliceIterator(targets), c)); !reflect.DeepEqual(got, tc.want) {
+			t.Errorf("OnlyClass(%s) = %v, want %v", tc.classes, got, tc.want)
+		}
+	}
+	if got := Collect(ExcludeClass(SliceIterator(targets), Public)); len(got) != 4 {
+		t.Errorf("ExcludeClass(public) = %v", got)
+	}
+}
@@ -0,0 +1,237 @@
+package tools
+
+import (
+	"bufio"
+	"encoding/csv"
+	"errors"
+	"fmt"
+	"io"
+	"os"
+	"reflect"
+	"strings"
+)
+
+// ErrNoColumn is returned when a column name is not in the header.
+var ErrNoColumn = errors.New("no such column")
+
+// CSVOptions tunes a CSVReader. The zero value reads comma-separated rows
+// without a header.
+type CSVOptions struct {
+	Comma     rune // field delimiter, ',' if zero
+	Comment   rune // lines starting with it are skipped, none if zero
+	Header    bool // the first row names the columns
+	TrimSpace bool // trim leading and trailing white space of every field
+}
+
+// CSVError is an error in a CSV file with the number of the row it is in,
+// counting the header as row 1. Row counts records, not lines: comment
+// lines and line breaks inside quoted fields are not counted. A wrapped
+// *csv.ParseError has the line number in the file.
+type CSVError struct {
+	Path string
+	Row  int
+	Err  error
+}
+
+func (e *CSVError) Error() string {
+	if e.Path == "" {
+		return fmt.Sprintf("row %d: %v", e.Row, e.Err)
+	}
+	return fmt.Sprintf("%s: row %d: %v", e.Path, e.Row, e.Err)
+}
+
+func (e *CSVError) Unwrap() error { return e.Err }
+
+// CSVRow is one record. Rows may be ragged: fields missing at the end read
+// as empty strings.
+type CSVRow struct {
+	Row    int // record number, see CSVError
+	Fields []string
+	index  map[string]int
+}
+
+// Index returns field i, or "" if the row is shorter.
+func (r CSVRow) Index(i int) string {
+	if i < 0 || i >= len(r.Fields) {
+		return ""
+	}
+	return r.Fields[i]
+}
+
+// Lookup returns the field of the named column and whether the header has
+// that column.
+func (r CSVRow) Lookup(name string) (string, bool) {
+	i, ok := r.index[normalizeColumn(name)]
+	if !ok {
+		return "", false
+	}
+	return r.Index(i), true
+}
+
+// Get returns the field of the named column, "" if there is none.
+func (r CSVRow) Get(name string) string {
+	v, _ := r.Lookup(name)
+	return v
+}
+
+func normalizeColumn(name string) string {
+	return strings.ToLower(strings.TrimSpace(name))
+}
+
+//================================================================================
+
+// CSVReader streams the rows of a CSV file, e.g. an Excel export, instead of
+// loading it into memory. A UTF-8 byte order mark at the start is dropped.
+type CSVReader struct {
+	path   string
+	opt    CSVOptions
+	r      *csv.Reader
+	closer io.Closer
+	header []string
+	index  map[string]int
+	row    int
+	err    error
+
+	bindType reflect.Type // struct type binds were made for, see Decode
+	binds    []csvBinding
+}
+
+// NewCSVReader reads rows from r, consuming the header row if opt.Header is set.
+func NewCSVReader(r io.Reader, opt CSVOptions) (*CSVReader, error) {
+	br := bufio.NewReader(r)
+	if bom, err := br.Peek(3); err == nil && string(bom) == "\xef\xbb\xbf" {
+		br.Discard(3)
+	}
+	cr := csv.NewReader(br)
+	if opt.Comma != 0 {
+		cr.Comma = opt.Comma
+	}
+	cr.Comment = opt.Comment
+	cr.FieldsPerRecord = -1
+	c := &CSVReader{opt: opt, r: cr, index: make(map[string]int)}
+	if opt.Header {
+		row, err := c.Next()
+		if err == io.EOF {
+			return c, nil
+		}
+		if err != nil {
+			return nil, err
+		}
+		c.header = row.Fields
+		for i, name := range c.header {
+			if _, dup := c.index[normalizeColumn(name)]; !dup {
+				c.index[normalizeColumn(name)] = i
+			}
+		}
+	}
+	return c, nil
+}
+
+// OpenCSV opens the CSV file at path; Close it when done.
+func OpenCSV(path string, opt CSVOptions) (*CSVReader, error) {
+	f, err := os.Open(path)
+	if err != nil {
+		return nil, err
+	}
+	c, err := NewCSVReader(f, opt)
+	if err != nil {
+		f.Close()
+		var ce *CSVError
+		if errors.As(err, &ce) {
+			ce.Path = path
+		}
+		return nil, err
+	}
+	c.path, c.closer = path, f
+	return c, nil
+}
+
+func (c *CSVReader) Close() error {
+	if c.closer == nil {
+		return nil
+	}
+	return c.closer.Close()
+}
+
+// Header returns the column names, nil without a header.
+func (c *CSVReader) Header() []string { return c.header }
+
+// Column returns the index of the named column; names are matched ignoring
+// case and surrounding space.
+func (c *CSVReader) Column(name string) (int, error) {
+	i, ok := c.index[normalizeColumn(name)]
+	if !ok {
+		return -1, fmt.Errorf("%w %q", ErrNoColumn, name)
+	}
+	return i, nil
+}
+
+// Next returns the next row, or io.EOF after the last one.
+func (c *CSVReader) Next() (CSVRow, error) {
+	fields, err := c.r.Read()
+	if err == io.EOF {
+		return CSVRow{}, err
+	}
+	c.row++
+	if err != nil {
+		return CSVRow{}, &CSVError{Path: c.path, Row: c.row, Err: err}
+	}
+	if c.opt.TrimSpace {
+		for i := range fields {
+			fields[i] = strings.TrimSpace(fields[i])
+		}
+	}
+	return CSVRow{Row: c.row, Fields: fields, index: c.index}, nil
+}
+
+// Each calls fn for every remaining row and stops at the first error,
+// which it returns; errors from fn are tagged with the row number unless
+// they already are a *CSVError.
+func (c *CSVReader) Each(fn func(CSVRow) error) error {
+	for {
+		row, err := c.Next()
+		if err == io.EOF {
+			return nil
+		}
+		if err != nil {
+			return err
+		}
+		if err := fn(row); err != nil {
+			var ce *CSVError
+			if errors.As(err, &ce) {
+				return err
+			}
+			return &CSVError{Path: c.path, Row: row.Row, Err: err}
+		}
+	}
+}
+
+// Values iterates over column i of the remaining rows, skipping empty
+// fields, so a sheet can feed a WorkPool or Map. Check Err once it is done.
+func (c *CSVReader) Values(i int) Iterator {
+	return &csvColumn{c: c, i: i}
+}
+
+// Err returns the error that stopped a Values iterator, if any.
+func (c *CSVReader) Err() error { return c.err }
+
+type csvColumn struct {
+	c *CSVReader
+	i int
+}
+
+func (it *csvColumn) Next() (string, bool) {
+	for it.c.err == nil {
+		row, err := it.c.Next()
+		if err != nil {
+			if err != io.EOF {
+				it.c.err = err
+			}
+			break
+		}
+		if v := row.Index(it.i); v != "" {
+			return v, true
+		}
+	}
+	return "", false
+}
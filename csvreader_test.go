@@ -0,0 +1,56 @@
+package tools
+
+import (
+	"encoding/csv"
+	"errors"
+	"strings"
+	"testing"
+)
+
+func TestCSVReaderEachErrors(t *testing.T) {
+	in := "name,port\na,80\nb,x\n"
+	c, err := NewCSVReader(strings.NewReader(in), CSVOptions{Header: true})
+	if err != nil {
+		t.Fatal(err)
+	}
+	err = c.Each(func(row CSVRow) error {
+		if row.Get("port") == "x" {
+			return errors.New("bad port")
+		}
+		return nil
+	})
+	if err == nil || err.Error() != "row 3: bad port" {
+		t.Fatalf("Each() = %v", err)
+	}
+
+	c, _ = NewCSVReader(strings.NewReader(in), CSVOptions{Header: true})
+	inner := &CSVError{Path: "other.csv", Row: 7, Err: errors.New("bad")}
+	err = c.Each(func(row CSVRow) error { return inner })
+	if err != inner {
+		t.Fatalf("Each() = %v, want %v unwrapped", err, inner)
+	}
+}
+
+func TestCSVReaderRowCountsRecords(t *testing.T) {
+	in := "\xef\xbb\xbfname,note\n# comment\na,\"two\nlines\"\nb,ok\nc,\"bad\"x\n"
+	c, err := NewCSVReader(strings.NewReader(in), CSVOptions{Header: true, Comment: '#'})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if h := c.Header(); len(h) != 2 || h[0] != "name" {
+		t.Fatalf("Header() = %q", h)
+	}
+	var rows []int
+	err = c.Each(func(row CSVRow) error {
+		rows = append(rows, row.Row)
+		return nil
+	})
+	if len(rows) != 2 || rows[0] != 2 || rows[1] != 3 {
+		t.Fatalf("rows = %v", rows)
+	}
+	var ce *CSVError
+	var pe *csv.ParseError
+	if !errors.As(err, &ce) || ce.Row != 4 || !errors.As(err, &pe) || pe.Line != 6 {
+		t.Fatalf("Each() = %v", err)
+	}
+}
@@ -0,0 +1,367 @@
+package tools
+
+import (
+	"encoding"
+	"encoding/csv"
+	"errors"
+	"fmt"
+	"io"
+	"reflect"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// ErrMissingColumn is returned when the header lacks a required column.
+var ErrMissingColumn = errors.New("missing required column")
+
+// CSVUnmarshaler is implemented by field types that parse themselves from
+// a CSV field.
+type CSVUnmarshaler interface {
+	UnmarshalCSV(field string) error
+}
+
+// CSVMarshaler is implemented by field types that format themselves as a
+// CSV field.
+type CSVMarshaler interface {
+	MarshalCSV() (string, error)
+}
+
+// csvTimeLayouts are tried in order for time.Time fields without a layout.
+var csvTimeLayouts = []string{
+	time.RFC3339,
+	"2006-01-02 15:04:05",
+	"2006-01-02 15:04",
+	"2006-01-02",
+	"2006/1/2 15:04:05",
+	"2006/1/2 15:04",
+	"2006/1/2",
+}
+
+var (
+	timeType     = reflect.TypeOf(time.Time{})
+	durationType = reflect.TypeOf(time.Duration(0))
+)
+
+// csvField maps a struct field to a column. The tag is
+// `csv:"name[,required][,layout=2006-01-02]"`; "-" skips the field and
+// fields without a tag use their Go name.
+type csvField struct {
+	name     string
+	index    []int
+	required bool
+	layout   string
+}
+
+func csvFields(t reflect.Type) []csvField {
+	var fields []csvField
+	for i := 0; i < t.NumField(); i++ {
+		sf := t.Field(i)
+		tag, tagged := sf.Tag.Lookup("csv")
+		if tag == "-" {
+			continue
+		}
+		if sf.Anonymous && !tagged && sf.Type.Kind() == reflect.Struct && sf.Type != timeType {
+			for _, f := range csvFields(sf.Type) {
+				f.index = append([]int{i}, f.index...)
+				fields = append(fields, f)
+			}
+			continue
+		}
+		if sf.PkgPath != "" {
+			continue // unexported
+		}
+		opts := strings.Split(tag, ",")
+		f := csvField{name: opts[0], index: []int{i}}
+		if f.name == "" {
+			f.name = sf.Name
+		}
+		for _, opt := range opts[1:] {
+			switch {
+			case opt == "required":
+				f.required = true
+			case strings.HasPrefix(opt, "layout="):
+				f.layout = strings.TrimPrefix(opt, "layout=")
+			}
+		}
+		fields = append(fields, f)
+	}
+	return fields
+}
+
+type csvBinding struct {
+	field  csvField
+	column int
+}
+
+// bind matches the fields of t against the header.
+func (c *CSVReader) bind(t reflect.Type) ([]csvBinding, error) {
+	if c.header == nil {
+		return nil, errors.New("decoding into structs needs CSVOptions.Header")
+	}
+	var binds []csvBinding
+	var missing []string
+	for _, f := range csvFields(t) {
+		col, ok := c.index[normalizeColumn(f.name)]
+		if !ok {
+			if f.required {
+				missing = append(missing, f.name)
+			}
+			continue
+		}
+		binds = append(binds, csvBinding{field: f, column: col})
+	}
+	if len(missing) > 0 {
+		return nil, &CSVError{Path: c.path, Row: 1, Err: fmt.Errorf("%w: %s", ErrMissingColumn, strings.Join(missing, ", "))}
+	}
+	return binds, nil
+}
+
+// Decode reads the next row into the struct v points to, matching fields to
+// header columns by their csv tag. It returns io.EOF after the last row.
+// Empty fields leave the struct field untouched.
+func (c *CSVReader) Decode(v interface{}) error {
+	rv := reflect.ValueOf(v)
+	if rv.Kind() != reflect.Ptr || rv.IsNil() || rv.Elem().Kind() != reflect.Struct {
+		return fmt.Errorf("csv: Decode needs a pointer to a struct, not %T", v)
+	}
+	rv = rv.Elem()
+	if c.bindType != rv.Type() {
+		binds, err := c.bind(rv.Type())
+		if err != nil {
+			return err
+		}
+		c.binds, c.bindType = binds, rv.Type()
+	}
+	row, err := c.Next()
+	if err != nil {
+		return err
+	}
+	for _, b := range c.binds {
+		s := row.Index(b.column)
+		fv := rv.FieldByIndex(b.field.index)
+		if err := decodeCSVField(fv, s, b.field.layout); err != nil {
+			return &CSVError{Path: c.path, Row: row.Row, Err: fmt.Errorf("column %s: %w", b.field.name, err)}
+		}
+	}
+	return nil
+}
+
+// DecodeAll decodes the remaining rows into the slice v points to, a
+// *[]T or *[]*T of structs.
+func (c *CSVReader) DecodeAll(v interface{}) error {
+	rv := reflect.ValueOf(v)
+	if rv.Kind() != reflect.Ptr || rv.IsNil() || rv.Elem().Kind() != reflect.Slice {
+		return fmt.Errorf("csv: DecodeAll needs a pointer to a slice, not %T", v)
+	}
+	slice := rv.Elem()
+	elem := slice.Type().Elem()
+	isPtr := elem.Kind() == reflect.Ptr
+	if isPtr {
+		elem = elem.Elem()
+	}
+	for {
+		item := reflect.New(elem)
+		err := c.Decode(item.Interface())
+		if err == io.EOF {
+			return nil
+		}
+		if err != nil {
+			return err
+		}
+		if !isPtr {
+			item = item.Elem()
+		}
+		slice.Set(reflect.Append(slice, item))
+	}
+}
+
+func decodeCSVField(v reflect.Value, s, layout string) error {
+	if s == "" {
+		return nil
+	}
+	if v.Kind() == reflect.Ptr {
+		if v.IsNil() {
+			v.Set(reflect.New(v.Type().Elem()))
+		}
+		v = v.Elem()
+	}
+	if v.CanAddr() && v.Type() != timeType {
+		switch u := v.Addr().Interface().(type) {
+		case CSVUnmarshaler:
+			return u.UnmarshalCSV(s)
+		case encoding.TextUnmarshaler:
+			return u.UnmarshalText([]byte(s))
+		}
+	}
+	switch {
+	case v.Type() == timeType:
+		t, err := parseCSVTime(s, layout)
+		if err != nil {
+			return err
+		}
+		v.Set(reflect.ValueOf(t))
+		return nil
+	case v.Type() == durationType:
+		d, err := time.ParseDuration(s)
+		if err != nil {
+			return err
+		}
+		v.SetInt(int64(d))
+		return nil
+	}
+	switch v.Kind() {
+	case reflect.String:
+		v.SetString(s)
+	case reflect.Bool:
+		switch strings.ToLower(s) {
+		case "yes", "y":
+			v.SetBool(true)
+		case "no", "n":
+			v.SetBool(false)
+		default:
+			b, err := strconv.ParseBool(s)
+			if err != nil {
+				return err
+			}
+			v.SetBool(b)
+		}
+	case reflect.Int, reflect.Int8, reflect.Int16, reflect.Int32, reflect.Int64:
+		n, err := strconv.ParseInt(s, 10, v.Type().Bits())
+		if err != nil {
+			return err
+		}
+		v.SetInt(n)
+	case reflect.Uint, reflect.Uint8, reflect.Uint16, reflect.Uint32, reflect.Uint64:
+		n, err := strconv.ParseUint(s, 10, v.Type().Bits())
+		if err != nil {
+			return err
+		}
+		v.SetUint(n)
+	case reflect.Float32, reflect.Float64:
+		f, err := strconv.ParseFloat(s, v.Type().Bits())
+		if err != nil {
+			return err
+		}
+		v.SetFloat(f)
+	default:
+		return fmt.Errorf("unsupported field type %v", v.Type())
+	}
+	return nil
+}
+
+func parseCSVTime(s, layout string) (time.Time, error) {
+	if layout != "" {
+		return time.ParseInLocation(layout, s, time.Local)
+	}
+	for _, l := range csvTimeLayouts {
+		if t, err := time.ParseInLocation(l, s, time.Local); err == nil {
+			return t, nil
+		}
+	}
+	return time.Time{}, fmt.Errorf("cannot parse %q as a time", s)
+}
+
+//================================================================================
+
+// WriteCSV writes the structs of v, a slice of structs or struct pointers,
+// to w with a header row taken from their csv tags. Only opt.Comma is used.
+func WriteCSV(w io.Writer, v interface{}, opt CSVOptions) error {
+	rv := reflect.ValueOf(v)
+	if rv.Kind() != reflect.Slice {
+		return fmt.Errorf("csv: WriteCSV needs a slice, not %T", v)
+	}
+	elem := rv.Type().Elem()
+	if elem.Kind() == reflect.Ptr {
+		elem = elem.Elem()
+	}
+	if elem.Kind() != reflect.Struct {
+		return fmt.Errorf("csv: WriteCSV needs a slice of structs, not %T", v)
+	}
+	fields := csvFields(elem)
+	cw := csv.NewWriter(w)
+	if opt.Comma != 0 {
+		cw.Comma = opt.Comma
+	}
+	record := make([]string, len(fields))
+	for i, f := range fields {
+		record[i] = f.name
+	}
+	if err := cw.Write(record); err != nil {
+		return err
+	}
+	for i := 0; i < rv.Len(); i++ {
+		item := rv.Index(i)
+		if item.Kind() == reflect.Ptr {
+			if item.IsNil() {
+				continue
+			}
+			item = item.Elem()
+		}
+		for j, f := range fields {
+			s, err := encodeCSVField(item.FieldByIndex(f.index), f.layout)
+			if err != nil {
+				return &CSVError{Row: i + 2, Err: fmt.Errorf("column %s: %w", f.name, err)}
+			}
+			record[j] = s
+		}
+		if err := cw.Write(record); err != nil {
+			return err
+		}
+	}
+	cw.Flush()
+	return cw.Error()
+}
+
+func encodeCSVField(v reflect.Value, layout string) (string, error) {
+	if v.Kind() == reflect.Ptr {
+		if v.IsNil() {
+			return "", nil
+		}
+		v = v.Elem()
+	}
+	if v.Type() != timeType {
+		switch m := v.Interface().(type) {
+		case CSVMarshaler:
+			return m.MarshalCSV()
+		case encoding.TextMarshaler:
+			b, err := m.MarshalText()
+			return string(b), err
+		}
+		if v.CanAddr() {
+			switch m := v.Addr().Interface().(type) {
+			case CSVMarshaler:
+				return m.MarshalCSV()
+			case encoding.TextMarshaler:
+				b, err := m.MarshalText()
+				return string(b), err
+			}
+		}
+	}
+	switch {
+	case v.Type() == timeType:
+		t := v.Interface().(time.Time)
+		if t.IsZero() {
+			return "", nil
+		}
+		if layout == "" {
+			layout = time.RFC3339
+		}
+		return t.Format(layout), nil
+	case v.Type() == durationType:
+		return time.Duration(v.Int()).String(), nil
+	}
+	switch v.Kind() {
+	case reflect.String:
+		return v.String(), nil
+	case reflect.Bool:
+		return strconv.FormatBool(v.Bool()), nil
+	case reflect.Int, reflect.Int8, reflect.Int16, reflect.Int32, reflect.Int64:
+		return strconv.FormatInt(v.Int(), 10), nil
+	case reflect.Uint, reflect.Uint8, reflect.Uint16, reflect.Uint32, reflect.Uint64:
+		return strconv.FormatUint(v.Uint(), 10), nil
+	case reflect.Float32, reflect.Float64:
+		return strconv.FormatFloat(v.Float(), 'f', -1, v.Type().Bits()), nil
+	}
+	return "", fmt.Errorf("unsupported field type %v", v.Type())
+}
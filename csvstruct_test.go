@@ -0,0 +1,92 @@
+package tools
+
+import (
+	"bytes"
+	"errors"
+	"net"
+	"reflect"
+	"strings"
+	"testing"
+	"time"
+)
+
+type csvBase struct {
+	Host string `csv:"host,required"`
+}
+
+type csvTarget struct {
+	csvBase
+	Port    int           `csv:"port,required"`
+	IP      net.IP        `csv:"ip"`
+	Open    bool          `csv:"open"`
+	RTT     time.Duration `csv:"rtt"`
+	Score   float64       `csv:"score"`
+	Seen    time.Time     `csv:"seen,layout=2006-01-02"`
+	Banner  *string       `csv:"banner"`
+	Skipped string        `csv:"-"`
+	note    string
+}
+
+func TestCSVStructRoundTrip(t *testing.T) {
+	banner := "SSH-2.0"
+	want := []csvTarget{
+		{
+			csvBase: csvBase{Host: "a, b"},
+			Port:    22,
+			IP:      net.ParseIP("10.0.0.1"),
+			Open:    true,
+			RTT:     1500 * time.Microsecond,
+			Score:   0.25,
+			Seen:    time.Date(2020, 3, 1, 0, 0, 0, 0, time.Local),
+			Banner:  &banner,
+		},
+		{csvBase: csvBase{Host: "c"}, Port: 80},
+	}
+	var buf bytes.Buffer
+	if err := WriteCSV(&buf, want, CSVOptions{}); err != nil {
+		t.Fatal(err)
+	}
+	out := buf.String()
+	if !strings.HasPrefix(out, "host,port,ip,open,rtt,score,seen,banner\n\"a, b\",22,10.0.0.1,true,1.5ms,0.25,2020-03-01,SSH-2.0\n") {
+		t.Fatalf("WriteCSV wrote:\n%s", out)
+	}
+
+	c, err := NewCSVReader(strings.NewReader(out), CSVOptions{Header: true})
+	if err != nil {
+		t.Fatal(err)
+	}
+	var got []csvTarget
+	if err := c.DecodeAll(&got); err != nil {
+		t.Fatal(err)
+	}
+	if len(got) != 2 || !got[0].Seen.Equal(want[0].Seen) {
+		t.Fatalf("DecodeAll() = %+v", got)
+	}
+	got[0].Seen = want[0].Seen
+	if !reflect.DeepEqual(got, want) {
+		t.Fatalf("DecodeAll() = %+v, want %+v", got, want)
+	}
+}
+
+func TestCSVStructErrors(t *testing.T) {
+	c, _ := NewCSVReader(strings.NewReader("host,ip\na,10.0.0.1\n"), CSVOptions{Header: true})
+	var v csvTarget
+	err := c.Decode(&v)
+	var ce *CSVError
+	if !errors.Is(err, ErrMissingColumn) || !errors.As(err, &ce) || ce.Row != 1 || !strings.Contains(err.Error(), "port") {
+		t.Fatalf("Decode() = %v, want missing column port", err)
+	}
+
+	type tags struct {
+		Host string   `csv:"host"`
+		Tags []string `csv:"tags"`
+	}
+	c, _ = NewCSVReader(strings.NewReader("host,tags\na,x\n"), CSVOptions{Header: true})
+	var tv tags
+	if err := c.Decode(&tv); err == nil || !strings.Contains(err.Error(), "column tags: unsupported field type []string") {
+		t.Fatalf("Decode() = %v", err)
+	}
+	if err := WriteCSV(&bytes.Buffer{}, []tags{{Host: "a"}}, CSVOptions{}); err == nil || !strings.Contains(err.Error(), "unsupported field type") {
+		t.Fatalf("WriteCSV() = %v", err)
+	}
+}
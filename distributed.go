@@ -0,0 +1,454 @@
+package tools
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io/ioutil"
+	"log"
+	"net"
+	"net/http"
+	"os"
+	"strings"
+	"sync"
+	"time"
+)
+
+// ErrLeaseLost means the coordinator expired a lease and handed its tasks to
+// another worker.
+var ErrLeaseLost = errors.New("lease lost")
+
+// RemoteResult is the outcome of one task run by a remote worker.
+type RemoteResult struct {
+	Key    string `json:"key"`
+	Error  string `json:"error,omitempty"`
+	Worker string `json:"-"`
+}
+
+type leaseRequest struct {
+	Worker string `json:"worker"`
+	Max    int    `json:"max"`
+}
+
+type leaseResponse struct {
+	Lease string   `json:"lease,omitempty"`
+	Tasks []string `json:"tasks,omitempty"`
+	TTL   int64    `json:"ttl_ms,omitempty"`
+	Done  bool     `json:"done,omitempty"`
+}
+
+type heartbeatRequest struct {
+	Lease string `json:"lease"`
+}
+
+type resultRequest struct {
+	Lease   string         `json:"lease"`
+	Results []RemoteResult `json:"results"`
+}
+
+type lease struct {
+	id      string
+	worker  string
+	keys    map[string]bool
+	expires time.Time
+}
+
+//================================================================================
+
+// Coordinator hands the targets of src out to remote workers over HTTP/JSON.
+// Workers lease a batch of tasks and keep the lease alive with heartbeats;
+// when a lease expires its unfinished tasks go back to the queue, so work
+// of a dead worker is picked up by the others. Tasks are run at least once.
+//
+//	POST /lease     {"worker":"w1","max":100} -> {"lease":"..","tasks":[..],"ttl_ms":30000}
+//	POST /heartbeat {"lease":".."}            -> 200, or 410 once the lease is gone
+//	POST /result    {"lease":"..","results":[{"key":"..","error":".."}]}
+type Coordinator struct {
+	LeaseTTL time.Duration
+	MaxBatch int
+
+	mu       sync.Mutex
+	src      Iterator
+	drained  bool     // src is exhausted
+	requeue  []string // keys of expired leases, served first
+	leases   map[string]*lease
+	nextID   int
+	done     int
+	failed   int
+	hooks    []func(RemoteResult)
+	finished chan struct{}
+}
+
+// CoordinatorStats is a snapshot of a Coordinator.
+type CoordinatorStats struct {
+	Queued int // expired tasks waiting to be leased again
+	Leased int
+	Leases int
+	Done   int
+	Failed int
+}
+
+// NewCoordinator serves the targets of src with leases of 30 seconds.
+func NewCoordinator(src Iterator) *Coordinator {
+	return &Coordinator{
+		LeaseTTL: 30 * time.Second,
+		MaxBatch: 100,
+		src:      src,
+		leases:   make(map[string]*lease),
+		finished: make(chan struct{}),
+	}
+}
+
+// OnResult registers fn to be called with every result. Hooks run one at a
+// time and must not call back into c.
+func (c *Coordinator) OnResult(fn func(RemoteResult)) {
+	c.mu.Lock()
+	c.hooks = append(c.hooks, fn)
+	c.mu.Unlock()
+}
+
+// Done is closed once every task has a result.
+func (c *Coordinator) Done() <-chan struct{} { return c.finished }
+
+func (c *Coordinator) Stats() CoordinatorStats {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	c.expireLocked(time.Now())
+	s := CoordinatorStats{Queued: len(c.requeue), Leases: len(c.leases), Done: c.done, Failed: c.failed}
+	for _, l := range c.leases {
+		s.Leased += len(l.keys)
+	}
+	return s
+}
+
+// Serve listens on addr and serves c in the background, see ServeMetrics.
+func (c *Coordinator) Serve(addr string) (*http.Server, error) {
+	ln, err := net.Listen("tcp", addr)
+	if err != nil {
+		return nil, err
+	}
+	srv := &http.Server{Addr: ln.Addr().String(), Handler: c}
+	go srv.Serve(ln)
+	return srv, nil
+}
+
+func (c *Coordinator) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodPost {
+		http.Error(w, "method not allowed", http.StatusMethodNotAllowed)
+		return
+	}
+	var err error
+	var resp interface{}
+	switch r.URL.Path {
+	case "/lease":
+		var req leaseRequest
+		if err = json.NewDecoder(r.Body).Decode(&req); err == nil {
+			resp = c.grant(req)
+		}
+	case "/heartbeat":
+		var req heartbeatRequest
+		if err = json.NewDecoder(r.Body).Decode(&req); err == nil && !c.extend(req.Lease) {
+			http.Error(w, ErrLeaseLost.Error(), http.StatusGone)
+			return
+		}
+	case "/result":
+		var req resultRequest
+		if err = json.NewDecoder(r.Body).Decode(&req); err == nil {
+			c.complete(req)
+		}
+	default:
+		http.NotFound(w, r)
+		return
+	}
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+	w.Header().Set("Content-Type", "application/json")
+	if resp == nil {
+		resp = struct{}{}
+	}
+	json.NewEncoder(w).Encode(resp)
+}
+
+func (c *Coordinator) grant(req leaseRequest) leaseResponse {
+	max := req.Max
+	if max < 1 || max > c.MaxBatch {
+		max = c.MaxBatch
+	}
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	now := time.Now()
+	c.expireLocked(now)
+
+	var keys []string
+	for len(keys) < max && len(c.requeue) > 0 {
+		keys = append(keys, c.requeue[0])
+		c.requeue = c.requeue[1:]
+	}
+	for len(keys) < max && !c.drained {
+		key, ok := c.src.Next()
+		if !ok {
+			c.drained = true
+			break
+		}
+		keys = append(keys, key)
+	}
+	if len(keys) == 0 {
+		// everything is leased; the worker asks again later
+		return leaseResponse{Done: c.finishedLocked()}
+	}
+	c.nextID++
+	l := &lease{
+		id:      fmt.Sprintf("%s-%d", req.Worker, c.nextID),
+		worker:  req.Worker,
+		keys:    make(map[string]bool, len(keys)),
+		expires: now.Add(c.LeaseTTL),
+	}
+	for _, k := range keys {
+		l.keys[k] = true
+	}
+	c.leases[l.id] = l
+	return leaseResponse{Lease: l.id, Tasks: keys, TTL: int64(c.LeaseTTL / time.Millisecond)}
+}
+
+func (c *Coordinator) extend(id string) bool {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	now := time.Now()
+	c.expireLocked(now)
+	l, ok := c.leases[id]
+	if ok {
+		l.expires = now.Add(c.LeaseTTL)
+	}
+	return ok
+}
+
+// complete records results; only the current holder of a task may report
+// it, so a task re-leased after expiry is not counted twice.
+func (c *Coordinator) complete(req resultRequest) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	c.expireLocked(time.Now())
+	l, ok := c.leases[req.Lease]
+	if !ok {
+		return
+	}
+	for _, res := range req.Results {
+		if !l.keys[res.Key] {
+			continue
+		}
+		delete(l.keys, res.Key)
+		if res.Error != "" {
+			c.failed++
+		} else {
+			c.done++
+		}
+		res.Worker = l.worker
+		for _, hook := range c.hooks {
+			hook(res)
+		}
+	}
+	if len(l.keys) == 0 {
+		delete(c.leases, l.id)
+	}
+	c.finishedLocked()
+}
+
+func (c *Coordinator) expireLocked(now time.Time) {
+	for id, l := range c.leases {
+		if now.Before(l.expires) {
+			continue
+		}
+		log.Printf("coordinator: lease %s of %s expired, requeuing %d tasks", id, l.worker, len(l.keys))
+		for k := range l.keys {
+			c.requeue = append(c.requeue, k)
+		}
+		delete(c.leases, id)
+	}
+}
+
+// finishedLocked closes finished once all work is reported.
+func (c *Coordinator) finishedLocked() bool {
+	if !c.drained || len(c.requeue) > 0 || len(c.leases) > 0 {
+		return false
+	}
+	select {
+	case <-c.finished:
+	default:
+		close(c.finished)
+	}
+	return true
+}
+
+//================================================================================
+
+// RemoteWorker pulls tasks from a Coordinator and runs them on a local
+// WorkPool, one lease at a time. Configure may set up the pool of every
+// lease, e.g. UseCheckpoint or SetMaxPanics: keys the checkpoint skips are
+// reported as done and tasks the pool drops unrun as failed, so no key is
+// left behind in a lease that expires and is handed out again.
+type RemoteWorker struct {
+	URL       string        // base URL of the coordinator, e.g. http://10.0.0.1:7000
+	ID        string        // defaults to hostname-pid
+	Batch     int           // tasks per lease
+	Workers   int           // concurrency of the local pool
+	Poll      time.Duration // wait before asking again when nothing is free
+	Configure func(p *WorkPool)
+	Client    *http.Client
+}
+
+// NewRemoteWorker returns a worker for the coordinator at url.
+func NewRemoteWorker(url string, workers int) *RemoteWorker {
+	host, _ := os.Hostname()
+	return &RemoteWorker{
+		URL:     strings.TrimRight(url, "/"),
+		ID:      fmt.Sprintf("%s-%d", host, os.Getpid()),
+		Batch:   4 * workers,
+		Workers: workers,
+		Poll:    time.Second,
+		Client:  HTTPClient,
+	}
+}
+
+// Run leases tasks and runs fn on each key until the coordinator reports
+// that all work is done or ctx is cancelled. Task failures are reported to
+// the coordinator, not returned.
+func (w *RemoteWorker) Run(ctx context.Context, fn func(ctx context.Context, key string) error) error {
+	for {
+		var lr leaseResponse
+		_, err := DefaultRetryPolicy().Do(ctx, func(ctx context.Context) error {
+			return w.call(ctx, "/lease", leaseRequest{Worker: w.ID, Max: w.Batch}, &lr)
+		})
+		switch {
+		case err != nil:
+			return err
+		case lr.Done:
+			return nil
+		case len(lr.Tasks) == 0:
+			select {
+			case <-time.After(w.Poll):
+				continue
+			case <-ctx.Done():
+				return ctx.Err()
+			}
+		}
+		if err := w.runLease(ctx, lr, fn); err != nil && !errors.Is(err, ErrLeaseLost) {
+			return err
+		}
+	}
+}
+
+func (w *RemoteWorker) runLease(ctx context.Context, lr leaseResponse, fn func(ctx context.Context, key string) error) error {
+	p := NewWorkPool(ctx, w.Workers)
+	if w.Configure != nil {
+		w.Configure(p)
+	}
+	var mu sync.Mutex
+	var results []RemoteResult
+	reported := make(map[string]bool, len(lr.Tasks))
+	p.OnResult(func(res TaskResult) {
+		r := RemoteResult{Key: res.Key}
+		if res.Err != nil {
+			r.Error = res.Err.Error()
+		}
+		mu.Lock()
+		results = append(results, r)
+		reported[r.Key] = true
+		mu.Unlock()
+	})
+	skipped := make(map[string]bool)
+	for _, key := range lr.Tasks {
+		key := key
+		n := p.Skipped()
+		p.SubmitTask(&Task{Key: key, Run: func(ctx context.Context) error { return fn(ctx, key) }})
+		if p.Skipped() > n {
+			skipped[key] = true
+		}
+	}
+
+	lost := make(chan struct{})
+	stop := make(chan struct{})
+	go func() {
+		interval := time.Duration(lr.TTL) * time.Millisecond / 3
+		if interval <= 0 {
+			interval = time.Second
+		}
+		ticker := time.NewTicker(interval)
+		defer ticker.Stop()
+		for {
+			select {
+			case <-ticker.C:
+				err := w.call(ctx, "/heartbeat", heartbeatRequest{Lease: lr.Lease}, nil)
+				var se *StatusError
+				if errors.As(err, &se) && se.StatusCode == http.StatusGone {
+					close(lost)
+					p.Cancel()
+					return
+				}
+			case <-stop:
+				return
+			}
+		}
+	}()
+	werr := p.Wait()
+	close(stop)
+
+	select {
+	case <-lost:
+		return ErrLeaseLost
+	default:
+	}
+	if ctx.Err() != nil {
+		return ctx.Err()
+	}
+	notRun := "not run"
+	var pe *PoolError
+	if errors.As(werr, &pe) && pe.Cause != nil {
+		notRun += ": " + pe.Cause.Error()
+	}
+	for _, key := range lr.Tasks {
+		switch {
+		case reported[key]:
+		case skipped[key]:
+			results = append(results, RemoteResult{Key: key})
+		default:
+			results = append(results, RemoteResult{Key: key, Error: notRun})
+		}
+	}
+	_, err := DefaultRetryPolicy().Do(ctx, func(ctx context.Context) error {
+		return w.call(ctx, "/result", resultRequest{Lease: lr.Lease, Results: results}, nil)
+	})
+	return err
+}
+
+func (w *RemoteWorker) call(ctx context.Context, path string, in, out interface{}) error {
+	body, err := json.Marshal(in)
+	if err != nil {
+		return err
+	}
+	req, err := http.NewRequest(http.MethodPost, w.URL+path, bytes.NewReader(body))
+	if err != nil {
+		return err
+	}
+	req.Header.Set("Content-Type", "application/json")
+	client := w.Client
+	if client == nil {
+		client = HTTPClient
+	}
+	resp, err := client.Do(req.WithContext(ctx))
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode != http.StatusOK {
+		ioutil.ReadAll(resp.Body)
+		return NewStatusError(resp)
+	}
+	if out == nil {
+		return nil
+	}
+	return json.NewDecoder(resp.Body).Decode(out)
+}
@@ -0,0 +1,161 @@
+package tools
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"net/http/httptest"
+	"sync"
+	"testing"
+	"time"
+)
+
+// testCoordinator serves n keys over loopback and counts the results per key.
+func testCoordinator(n int) (*Coordinator, *httptest.Server, func() map[string]int) {
+	keys := make([]string, n)
+	for i := range keys {
+		keys[i] = fmt.Sprintf("k%03d", i)
+	}
+	c := NewCoordinator(SliceIterator(keys))
+	c.LeaseTTL = 300 * time.Millisecond
+	c.MaxBatch = 10
+	var mu sync.Mutex
+	seen := make(map[string]int)
+	c.OnResult(func(res RemoteResult) {
+		mu.Lock()
+		seen[res.Key]++
+		mu.Unlock()
+	})
+	srv := httptest.NewServer(c)
+	return c, srv, func() map[string]int {
+		mu.Lock()
+		defer mu.Unlock()
+		return seen
+	}
+}
+
+func waitCoordinator(t *testing.T, c *Coordinator) {
+	select {
+	case <-c.Done():
+	case <-time.After(5 * time.Second):
+		t.Fatalf("coordinator not done: %+v", c.Stats())
+	}
+}
+
+func TestCoordinatorRequeuesDeadWorker(t *testing.T) {
+	c, srv, seen := testCoordinator(100)
+	defer srv.Close()
+
+	// the dead worker takes a lease and stops without reporting or
+	// heartbeating, so its lease has to expire
+	dctx, kill := context.WithCancel(context.Background())
+	dead := NewRemoteWorker(srv.URL, 2)
+	dead.ID = "dead"
+	started := make(chan string, dead.Batch)
+	deadDone := make(chan error, 1)
+	go func() {
+		deadDone <- dead.Run(dctx, func(ctx context.Context, key string) error {
+			started <- key
+			<-ctx.Done()
+			return ctx.Err()
+		})
+	}()
+	<-started
+	kill()
+	if err := <-deadDone; err != context.Canceled {
+		t.Fatalf("dead worker Run() = %v", err)
+	}
+	if st := c.Stats(); st.Leases != 1 || st.Leased == 0 {
+		t.Fatalf("after the dead worker: %+v", st)
+	}
+
+	var wg sync.WaitGroup
+	for i := 0; i < 3; i++ {
+		w := NewRemoteWorker(srv.URL, 4)
+		w.ID = fmt.Sprintf("w%d", i)
+		w.Poll = 20 * time.Millisecond
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			err := w.Run(context.Background(), func(ctx context.Context, key string) error {
+				if key == "k042" {
+					return errors.New("refused")
+				}
+				return nil
+			})
+			if err != nil {
+				t.Error(err)
+			}
+		}()
+	}
+	waitCoordinator(t, c)
+	wg.Wait()
+
+	if st := c.Stats(); st.Done != 99 || st.Failed != 1 || st.Leases != 0 || st.Queued != 0 {
+		t.Fatalf("Stats() = %+v", st)
+	}
+	got := seen()
+	if len(got) != 100 {
+		t.Fatalf("%d keys reported, want 100", len(got))
+	}
+	for key, n := range got {
+		if n != 1 {
+			t.Errorf("%s reported %d times", key, n)
+		}
+	}
+}
+
+func TestRemoteWorkerReportsSkippedTasks(t *testing.T) {
+	c, srv, seen := testCoordinator(20)
+	defer srv.Close()
+	path, cleanup := tempCheckpoint(t)
+	defer cleanup()
+	cp, err := OpenCheckpoint(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer cp.Close()
+	for i := 0; i < 20; i += 2 {
+		cp.MarkDone(fmt.Sprintf("k%03d", i))
+	}
+
+	w := NewRemoteWorker(srv.URL, 2)
+	w.Poll = 20 * time.Millisecond
+	w.Configure = func(p *WorkPool) { p.UseCheckpoint(cp) }
+	var mu sync.Mutex
+	ran := 0
+	err = w.Run(context.Background(), func(ctx context.Context, key string) error {
+		mu.Lock()
+		ran++
+		mu.Unlock()
+		return nil
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	waitCoordinator(t, c)
+	if st := c.Stats(); ran != 10 || st.Done != 20 || st.Failed != 0 || len(seen()) != 20 {
+		t.Fatalf("ran %d tasks, Stats() = %+v", ran, st)
+	}
+}
+
+func TestRemoteWorkerReportsDroppedTasks(t *testing.T) {
+	c, srv, seen := testCoordinator(20)
+	defer srv.Close()
+	w := NewRemoteWorker(srv.URL, 1)
+	w.Batch = 20
+	w.Configure = func(p *WorkPool) { p.SetMaxPanics(1) }
+	err := w.Run(context.Background(), func(ctx context.Context, key string) error {
+		if key == "k005" {
+			panic("boom")
+		}
+		return nil
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	waitCoordinator(t, c)
+	if st := c.Stats(); st.Done+st.Failed != 20 || st.Failed < 1 || len(seen()) != 20 {
+		t.Fatalf("Stats() = %+v", st)
+	}
+}
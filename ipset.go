@@ -0,0 +1,383 @@
+package tools
+
+import (
+	"math/big"
+	"math/bits"
+	"net"
+	"sort"
+	"strings"
+)
+
+type ipInterval struct {
+	first, last uint128
+}
+
+// IPSet is a set of IPv4 and IPv6 addresses kept as sorted, non-overlapping
+// intervals, so huge scopes cost memory per range rather than per address.
+// The zero value is an empty set.
+type IPSet struct {
+	v4, v6 []ipInterval
+}
+
+// NewIPSet builds a set from a target spec (see ParseTargets). Hostnames are
+// rejected since they are not addresses.
+func NewIPSet(spec string) (*IPSet, error) {
+	s := new(IPSet)
+	return s, s.Add(spec)
+}
+
+func (s *IPSet) list(v4 bool) *[]ipInterval {
+	if v4 {
+		return &s.v4
+	}
+	return &s.v6
+}
+
+// Add adds every address of spec: addresses, CIDRs, ranges and octet patterns.
+func (s *IPSet) Add(spec string) error {
+	return s.apply(spec, s.addRange)
+}
+
+// Remove removes every address of spec from the set.
+func (s *IPSet) Remove(spec string) error {
+	return s.apply(spec, s.removeRange)
+}
+
+func (s *IPSet) apply(spec string, fn func(first, last uint128, v4 bool)) error {
+	var errs TargetErrors
+	for _, token := range strings.FieldsFunc(spec, isTargetSeparator) {
+		t, err := parseTarget(token)
+		if err == nil {
+			err = specRanges(t, fn)
+		}
+		if err != nil {
+			errs = append(errs, &TargetError{Token: token, Err: err})
+		}
+	}
+	if len(errs) > 0 {
+		return errs
+	}
+	return nil
+}
+
+// specRanges calls fn for every contiguous range of a parsed target token.
+func specRanges(t targetSpec, fn func(first, last uint128, v4 bool)) error {
+	switch t := t.(type) {
+	case *rangeSpec:
+		fn(t.first, t.last, t.v4)
+	case *octetSpec:
+		for a := int(t.lo[0]); a <= int(t.hi[0]); a++ {
+			for b := int(t.lo[1]); b <= int(t.hi[1]); b++ {
+				for c := int(t.lo[2]); c <= int(t.hi[2]); c++ {
+					base := uint64(a)<<24 | uint64(b)<<16 | uint64(c)<<8
+					fn(uint128{0, base | uint64(t.lo[3])}, uint128{0, base | uint64(t.hi[3])}, true)
+				}
+			}
+		}
+	default:
+		return ErrBadAddress
+	}
+	return nil
+}
+
+// AddIP adds a single address.
+func (s *IPSet) AddIP(ip net.IP) {
+	if n, v4, err := ipToUint128(ip); err == nil {
+		s.addRange(n, n, v4)
+	}
+}
+
+// AddPrefix adds every address of ipnet, network and broadcast included.
+func (s *IPSet) AddPrefix(ipnet *net.IPNet) {
+	first, last, v4 := prefixBounds(ipnet, false)
+	s.addRange(first, last, v4)
+}
+
+// AddRange adds the addresses from first to last inclusive.
+func (s *IPSet) AddRange(first, last net.IP) error {
+	a, v4, err := ipToUint128(first)
+	if err != nil {
+		return err
+	}
+	b, lastV4, err := ipToUint128(last)
+	if err != nil {
+		return err
+	}
+	if v4 != lastV4 || a.cmp(b) > 0 {
+		return ErrBadRange
+	}
+	s.addRange(a, b, v4)
+	return nil
+}
+
+// RemoveIP removes a single address.
+func (s *IPSet) RemoveIP(ip net.IP) {
+	if n, v4, err := ipToUint128(ip); err == nil {
+		s.removeRange(n, n, v4)
+	}
+}
+
+// AddSet adds every address of o (union).
+func (s *IPSet) AddSet(o *IPSet) {
+	for _, iv := range o.v4 {
+		s.addRange(iv.first, iv.last, true)
+	}
+	for _, iv := range o.v6 {
+		s.addRange(iv.first, iv.last, false)
+	}
+}
+
+// RemoveSet removes every address of o (subtraction).
+func (s *IPSet) RemoveSet(o *IPSet) {
+	for _, iv := range o.v4 {
+		s.removeRange(iv.first, iv.last, true)
+	}
+	for _, iv := range o.v6 {
+		s.removeRange(iv.first, iv.last, false)
+	}
+}
+
+// Intersect returns a new set with the addresses present in both s and o.
+func (s *IPSet) Intersect(o *IPSet) *IPSet {
+	return &IPSet{
+		v4: intersectIntervals(s.v4, o.v4),
+		v6: intersectIntervals(s.v6, o.v6),
+	}
+}
+
+// Clone returns an independent copy of s.
+func (s *IPSet) Clone() *IPSet {
+	return &IPSet{
+		v4: append([]ipInterval(nil), s.v4...),
+		v6: append([]ipInterval(nil), s.v6...),
+	}
+}
+
+// Contains reports whether ip (an address string) is in the set.
+func (s *IPSet) Contains(ip string) bool {
+	return s.ContainsIP(net.ParseIP(ip))
+}
+
+func (s *IPSet) ContainsIP(ip net.IP) bool {
+	n, v4, err := ipToUint128(ip)
+	if err != nil {
+		return false
+	}
+	list := *s.list(v4)
+	i := sort.Search(len(list), func(i int) bool { return list[i].last.cmp(n) >= 0 })
+	return i < len(list) && list[i].first.cmp(n) <= 0
+}
+
+// Len returns the number of addresses in the set.
+func (s *IPSet) Len() *big.Int {
+	n := new(big.Int)
+	for _, list := range [][]ipInterval{s.v4, s.v6} {
+		for _, iv := range list {
+			n.Add(n, iv.last.sub(iv.first).big())
+			n.Add(n, big.NewInt(1))
+		}
+	}
+	return n
+}
+
+// Empty reports whether the set has no addresses.
+func (s *IPSet) Empty() bool {
+	return len(s.v4) == 0 && len(s.v6) == 0
+}
+
+// Equal reports whether s and o hold the same addresses.
+func (s *IPSet) Equal(o *IPSet) bool {
+	return equalIntervals(s.v4, o.v4) && equalIntervals(s.v6, o.v6)
+}
+
+// Prefixes returns the minimal list of CIDR blocks covering the set, IPv4 first.
+// It is the inverse of Hosts.
+func (s *IPSet) Prefixes() []*net.IPNet {
+	var nets []*net.IPNet
+	for _, iv := range s.v4 {
+		nets = appendPrefixes(nets, iv, true)
+	}
+	for _, iv := range s.v6 {
+		nets = appendPrefixes(nets, iv, false)
+	}
+	return nets
+}
+
+// String returns the minimal CIDR list, comma separated.
+func (s *IPSet) String() string {
+	nets := s.Prefixes()
+	list := make([]string, len(nets))
+	for i, n := range nets {
+		list[i] = n.String()
+	}
+	return strings.Join(list, ",")
+}
+
+// Iter walks every address of the set in ascending order, IPv4 first.
+func (s *IPSet) Iter() Iterator {
+	return &setIterator{v4: s.v4, v6: s.v6}
+}
+
+type setIterator struct {
+	v4, v6 []ipInterval
+	cur    *HostIterator
+}
+
+func (it *setIterator) Next() (string, bool) {
+	ip, ok := it.NextIP()
+	if !ok {
+		return "", false
+	}
+	return ip.String(), true
+}
+
+func (it *setIterator) NextIP() (net.IP, bool) {
+	for {
+		if it.cur != nil {
+			if ip, ok := it.cur.NextIP(); ok {
+				return ip, true
+			}
+		}
+		switch {
+		case len(it.v4) > 0:
+			it.cur = &HostIterator{cur: it.v4[0].first, last: it.v4[0].last, v4: true}
+			it.v4 = it.v4[1:]
+		case len(it.v6) > 0:
+			it.cur = &HostIterator{cur: it.v6[0].first, last: it.v6[0].last}
+			it.v6 = it.v6[1:]
+		default:
+			return nil, false
+		}
+	}
+}
+
+// IPSet returns the addresses of t with its exclusions removed; hostnames
+// are left out.
+func (t *Targets) IPSet() *IPSet {
+	s := new(IPSet)
+	for _, spec := range t.include {
+		specRanges(spec, s.addRange)
+	}
+	for _, spec := range t.exclude {
+		specRanges(spec, s.removeRange)
+	}
+	return s
+}
+
+//================================================================================
+
+func (s *IPSet) addRange(first, last uint128, v4 bool) {
+	p := s.list(v4)
+	list := *p
+	// intervals overlapping or adjacent to [first, last] are list[i:j]
+	i := sort.Search(len(list), func(i int) bool {
+		return first == (uint128{}) || list[i].last.cmp(first.subOne()) >= 0
+	})
+	j := sort.Search(len(list), func(j int) bool {
+		return list[j].first.cmp(last) > 0 && list[j].first.subOne().cmp(last) > 0
+	})
+	if i == j {
+		list = append(list, ipInterval{})
+		copy(list[i+1:], list[i:])
+		list[i] = ipInterval{first, last}
+		*p = list
+		return
+	}
+	if list[i].first.cmp(first) < 0 {
+		first = list[i].first
+	}
+	if list[j-1].last.cmp(last) > 0 {
+		last = list[j-1].last
+	}
+	list[i] = ipInterval{first, last}
+	*p = append(list[:i+1], list[j:]...)
+}
+
+func (s *IPSet) removeRange(first, last uint128, v4 bool) {
+	p := s.list(v4)
+	list := *p
+	i := sort.Search(len(list), func(i int) bool { return list[i].last.cmp(first) >= 0 })
+	j := sort.Search(len(list), func(j int) bool { return list[j].first.cmp(last) > 0 })
+	if i == j {
+		return
+	}
+	var keep []ipInterval
+	if list[i].first.cmp(first) < 0 {
+		keep = append(keep, ipInterval{list[i].first, first.subOne()})
+	}
+	if list[j-1].last.cmp(last) > 0 {
+		keep = append(keep, ipInterval{last.addOne(), list[j-1].last})
+	}
+	out := make([]ipInterval, 0, len(list)-(j-i)+len(keep))
+	out = append(out, list[:i]...)
+	out = append(out, keep...)
+	*p = append(out, list[j:]...)
+}
+
+func intersectIntervals(a, b []ipInterval) []ipInterval {
+	var out []ipInterval
+	for len(a) > 0 && len(b) > 0 {
+		first, last := a[0].first, a[0].last
+		if b[0].first.cmp(first) > 0 {
+			first = b[0].first
+		}
+		if b[0].last.cmp(last) < 0 {
+			last = b[0].last
+		}
+		if first.cmp(last) <= 0 {
+			out = append(out, ipInterval{first, last})
+		}
+		if a[0].last.cmp(b[0].last) < 0 {
+			a = a[1:]
+		} else {
+			b = b[1:]
+		}
+	}
+	return out
+}
+
+func equalIntervals(a, b []ipInterval) bool {
+	if len(a) != len(b) {
+		return false
+	}
+	for i := range a {
+		if a[i] != b[i] {
+			return false
+		}
+	}
+	return true
+}
+
+func trailingZeros(u uint128) int {
+	if u.lo != 0 {
+		return bits.TrailingZeros64(u.lo)
+	}
+	return 64 + bits.TrailingZeros64(u.hi)
+}
+
+// appendPrefixes splits an interval into the largest aligned CIDR blocks.
+func appendPrefixes(nets []*net.IPNet, iv ipInterval, v4 bool) []*net.IPNet {
+	width := 128
+	if v4 {
+		width = 32
+	}
+	first := iv.first
+	for {
+		k := trailingZeros(first)
+		if k > width {
+			k = width
+		}
+		for k > 0 && first.or(hostMask(k)).cmp(iv.last) > 0 {
+			k--
+		}
+		blockLast := first.or(hostMask(k))
+		nets = append(nets, &net.IPNet{
+			IP:   uint128ToIP(first, v4),
+			Mask: net.CIDRMask(width-k, width),
+		})
+		if blockLast == iv.last {
+			return nets
+		}
+		first = blockLast.addOne()
+	}
+}
@@ -0,0 +1,62 @@
+package tools
+
+import (
+	"strings"
+	"testing"
+)
+
+func TestIPSetAddRemovePrefixes(t *testing.T) {
+	s, err := NewIPSet("10.0.0.0/25")
+	if err != nil {
+		t.Fatal(err)
+	}
+	s.Add("10.0.0.128/25")
+	s.Add("10.0.1.0-10.0.1.255")
+	s.Remove("10.0.0.5")
+	s.Add("2001:db8::/127")
+	s.Remove("10.0.1.128/25")
+
+	want := []string{
+		"10.0.0.0/30", "10.0.0.4/32", "10.0.0.6/31", "10.0.0.8/29", "10.0.0.16/28",
+		"10.0.0.32/27", "10.0.0.64/26", "10.0.0.128/25", "10.0.1.0/25", "2001:db8::/127",
+	}
+	var got []string
+	for _, n := range s.Prefixes() {
+		got = append(got, n.String())
+	}
+	if strings.Join(got, " ") != strings.Join(want, " ") {
+		t.Fatalf("Prefixes() = %v, want %v", got, want)
+	}
+	if s.Len().Int64() != 256+128-1+2 || s.Contains("10.0.0.5") || !s.Contains("10.0.0.6") {
+		t.Fatalf("Len() = %v, Contains(10.0.0.5) = %v", s.Len(), s.Contains("10.0.0.5"))
+	}
+
+	back, err := NewIPSet(strings.Join(got, " "))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !back.Equal(s) {
+		t.Fatalf("round trip %v != %v", back, s)
+	}
+	back.Remove(strings.Join(got, " "))
+	if !back.Empty() {
+		t.Fatalf("removing every prefix left %v", back)
+	}
+}
+
+func TestIPSetOperations(t *testing.T) {
+	a, _ := NewIPSet("10.0.0.0/24")
+	b, _ := NewIPSet("10.0.0.128-10.0.1.10")
+	if got := a.Intersect(b).String(); got != "10.0.0.128/25" {
+		t.Fatalf("Intersect = %s", got)
+	}
+	c := a.Clone()
+	c.RemoveSet(b)
+	if got := c.String(); got != "10.0.0.0/25" || a.Len().Int64() != 256 {
+		t.Fatalf("RemoveSet = %s, original %v", got, a)
+	}
+	c.AddSet(b)
+	if c.Len().Int64() != 256+11 {
+		t.Fatalf("AddSet = %v", c)
+	}
+}
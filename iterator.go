@@ -0,0 +1,246 @@
+package tools
+
+import (
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"math/big"
+	"math/bits"
+	"net"
+)
+
+var (
+	ErrBadAddress = errors.New("invalid IP address")
+	ErrBadRange   = errors.New("invalid address range")
+)
+
+//================================================================================
+
+// uint128 is an address as a number, wide enough for both IPv4 and IPv6.
+type uint128 struct {
+	hi, lo uint64
+}
+
+func (u uint128) cmp(v uint128) int {
+	switch {
+	case u.hi < v.hi:
+		return -1
+	case u.hi > v.hi:
+		return 1
+	case u.lo < v.lo:
+		return -1
+	case u.lo > v.lo:
+		return 1
+	}
+	return 0
+}
+
+func (u uint128) add(v uint128) uint128 {
+	lo, carry := bits.Add64(u.lo, v.lo, 0)
+	hi, _ := bits.Add64(u.hi, v.hi, carry)
+	return uint128{hi, lo}
+}
+
+func (u uint128) sub(v uint128) uint128 {
+	lo, borrow := bits.Sub64(u.lo, v.lo, 0)
+	hi, _ := bits.Sub64(u.hi, v.hi, borrow)
+	return uint128{hi, lo}
+}
+
+func (u uint128) addOne() uint128 { return u.add(uint128{0, 1}) }
+func (u uint128) subOne() uint128 { return u.sub(uint128{0, 1}) }
+
+func (u uint128) and(v uint128) uint128 { return uint128{u.hi & v.hi, u.lo & v.lo} }
+func (u uint128) or(v uint128) uint128  { return uint128{u.hi | v.hi, u.lo | v.lo} }
+func (u uint128) not() uint128          { return uint128{^u.hi, ^u.lo} }
+
+func (u uint128) big() *big.Int {
+	b := new(big.Int).SetUint64(u.hi)
+	b.Lsh(b, 64)
+	return b.Or(b, new(big.Int).SetUint64(u.lo))
+}
+
+// hostMask returns a value with the low n bits set.
+func hostMask(n int) uint128 {
+	switch {
+	case n <= 0:
+		return uint128{}
+	case n < 64:
+		return uint128{0, 1<<uint(n) - 1}
+	case n < 128:
+		return uint128{1<<uint(n-64) - 1, ^uint64(0)}
+	}
+	return uint128{^uint64(0), ^uint64(0)}
+}
+
+// ipToUint128 converts ip to a number, reporting whether it is an IPv4 address.
+func ipToUint128(ip net.IP) (uint128, bool, error) {
+	if v4 := ip.To4(); v4 != nil {
+		return uint128{0, uint64(binary.BigEndian.Uint32(v4))}, true, nil
+	}
+	if v6 := ip.To16(); v6 != nil {
+		return uint128{binary.BigEndian.Uint64(v6[:8]), binary.BigEndian.Uint64(v6[8:])}, false, nil
+	}
+	return uint128{}, false, ErrBadAddress
+}
+
+func uint128ToIP(u uint128, v4 bool) net.IP {
+	if v4 {
+		ip := make(net.IP, net.IPv4len)
+		binary.BigEndian.PutUint32(ip, uint32(u.lo))
+		return ip
+	}
+	ip := make(net.IP, net.IPv6len)
+	binary.BigEndian.PutUint64(ip[:8], u.hi)
+	binary.BigEndian.PutUint64(ip[8:], u.lo)
+	return ip
+}
+
+func parseAddr(s string) (uint128, bool, error) {
+	ip := net.ParseIP(s)
+	if ip == nil {
+		return uint128{}, false, fmt.Errorf("%w: %q", ErrBadAddress, s)
+	}
+	return ipToUint128(ip)
+}
+
+// prefixBounds returns the first and last usable address of a prefix.
+// Following RFC 3021 a /31 (or /127) keeps both addresses and a /32 or /128
+// is a single host, so skipEdges only trims prefixes with two or more host bits.
+// IPv6 has no broadcast address; only the subnet-router anycast address is skipped.
+func prefixBounds(ipnet *net.IPNet, skipEdges bool) (first, last uint128, v4 bool) {
+	ones, size := ipnet.Mask.Size()
+	base, v4, _ := ipToUint128(ipnet.IP)
+	if v4 && size == 8*net.IPv6len {
+		// an IPv4-mapped prefix such as ::ffff:10.0.0.0/120; ParseCIDR
+		// masks the ::ffff: part away below /96, so ones >= 96 here
+		ones -= 96
+	}
+	if v4 {
+		size = 32
+	}
+	host := size - ones
+	first = base.and(hostMask(host).not())
+	last = first.or(hostMask(host))
+	if skipEdges && host >= 2 {
+		first = first.addOne()
+		if v4 {
+			last = last.subOne()
+		}
+	}
+	return first, last, v4
+}
+
+//================================================================================
+
+// Iterator is a lazy cursor over scan targets; ok is false once it is exhausted.
+type Iterator interface {
+	Next() (string, bool)
+}
+
+// HostIterator walks an address range one address at a time without
+// materializing it, for IPv4 and IPv6 alike.
+type HostIterator struct {
+	cur, last uint128
+	v4        bool
+	done      bool
+}
+
+// NewHostIterator walks every address of cidr. With skipEdges the network
+// and broadcast addresses are left out (see prefixBounds for /31 and /32).
+func NewHostIterator(cidr string, skipEdges bool) (*HostIterator, error) {
+	_, ipnet, err := net.ParseCIDR(cidr)
+	if err != nil {
+		return nil, err
+	}
+	first, last, v4 := prefixBounds(ipnet, skipEdges)
+	return &HostIterator{cur: first, last: last, v4: v4}, nil
+}
+
+// NewRangeIterator walks every address from start to end inclusive.
+func NewRangeIterator(start, end string) (*HostIterator, error) {
+	first, v4, err := parseAddr(start)
+	if err != nil {
+		return nil, err
+	}
+	last, lastV4, err := parseAddr(end)
+	if err != nil {
+		return nil, err
+	}
+	if v4 != lastV4 || first.cmp(last) > 0 {
+		return nil, fmt.Errorf("%w: %s-%s", ErrBadRange, start, end)
+	}
+	return &HostIterator{cur: first, last: last, v4: v4}, nil
+}
+
+// NextIP returns the next address, or false when the range is exhausted.
+func (it *HostIterator) NextIP() (net.IP, bool) {
+	if it.done {
+		return nil, false
+	}
+	ip := uint128ToIP(it.cur, it.v4)
+	if it.cur == it.last {
+		it.done = true
+	} else {
+		it.cur = it.cur.addOne()
+	}
+	return ip, true
+}
+
+func (it *HostIterator) Next() (string, bool) {
+	ip, ok := it.NextIP()
+	if !ok {
+		return "", false
+	}
+	return ip.String(), true
+}
+
+// Len returns how many addresses are left; it may exceed 2^64 for IPv6.
+func (it *HostIterator) Len() *big.Int {
+	if it.done {
+		return new(big.Int)
+	}
+	n := it.last.sub(it.cur).big()
+	return n.Add(n, big.NewInt(1))
+}
+
+// EachHost calls fn for every address of cidr until fn returns false.
+func EachHost(cidr string, skipEdges bool, fn func(ip string) bool) error {
+	it, err := NewHostIterator(cidr, skipEdges)
+	if err != nil {
+		return err
+	}
+	for ip, ok := it.Next(); ok; ip, ok = it.Next() {
+		if !fn(ip) {
+			break
+		}
+	}
+	return nil
+}
+
+// SliceIterator walks a list of targets, e.g. the failed keys of a Checkpoint.
+func SliceIterator(list []string) Iterator {
+	return &sliceIterator{list: list}
+}
+
+type sliceIterator struct {
+	list []string
+}
+
+func (it *sliceIterator) Next() (string, bool) {
+	if len(it.list) == 0 {
+		return "", false
+	}
+	s := it.list[0]
+	it.list = it.list[1:]
+	return s, true
+}
+
+// Collect drains it into a slice; only use it on small ranges.
+func Collect(it Iterator) []string {
+	var list []string
+	for s, ok := it.Next(); ok; s, ok = it.Next() {
+		list = append(list, s)
+	}
+	return list
+}
@@ -0,0 +1,44 @@
+package tools
+
+import "testing"
+
+func TestHostIteratorEdges(t *testing.T) {
+	for _, tc := range []struct {
+		cidr        string
+		skipEdges   bool
+		n           int
+		first, last string
+	}{
+		{"10.0.0.0/30", true, 2, "10.0.0.1", "10.0.0.2"},
+		{"10.0.0.0/30", false, 4, "10.0.0.0", "10.0.0.3"},
+		{"10.0.0.4/31", true, 2, "10.0.0.4", "10.0.0.5"},
+		{"10.0.0.4/31", false, 2, "10.0.0.4", "10.0.0.5"},
+		{"10.0.0.7/32", true, 1, "10.0.0.7", "10.0.0.7"},
+		{"10.0.0.7/32", false, 1, "10.0.0.7", "10.0.0.7"},
+		{"2001:db8::/126", true, 3, "2001:db8::1", "2001:db8::3"},
+		{"2001:db8::/127", true, 2, "2001:db8::", "2001:db8::1"},
+		{"2001:db8::/127", false, 2, "2001:db8::", "2001:db8::1"},
+		{"2001:db8::5/128", true, 1, "2001:db8::5", "2001:db8::5"},
+		{"2001:db8::5/128", false, 1, "2001:db8::5", "2001:db8::5"},
+		{"::ffff:10.0.0.0/120", true, 254, "10.0.0.1", "10.0.0.254"},
+		{"::ffff:10.0.0.0/120", false, 256, "10.0.0.0", "10.0.0.255"},
+		{"::ffff:10.0.0.9/128", true, 1, "10.0.0.9", "10.0.0.9"},
+	} {
+		it, err := NewHostIterator(tc.cidr, tc.skipEdges)
+		if err != nil {
+			t.Fatalf("%s: %v", tc.cidr, err)
+		}
+		hosts := Collect(it)
+		if len(hosts) != tc.n || hosts[0] != tc.first || hosts[len(hosts)-1] != tc.last {
+			t.Errorf("%s skipEdges=%v: %d hosts %s..%s, want %d %s..%s", tc.cidr, tc.skipEdges,
+				len(hosts), hosts[0], hosts[len(hosts)-1], tc.n, tc.first, tc.last)
+		}
+	}
+}
+
+func TestHostsMappedPrefix(t *testing.T) {
+	hosts, err := Hosts("::ffff:10.0.0.0/120")
+	if err != nil || len(hosts) != 254 {
+		t.Fatalf("Hosts() = %d hosts, %v", len(hosts), err)
+	}
+}
@@ -0,0 +1,73 @@
+package tools
+
+import "net"
+
+// LocalNetwork is an up, non-loopback interface with the prefixes assigned to it.
+type LocalNetwork struct {
+	Name     string
+	MAC      string
+	MTU      int
+	Prefixes []*net.IPNet // interface address plus its mask
+	Gateways []net.IP     // default gateways routed through this interface
+}
+
+// LocalNetworks lists the interfaces of this machine. Default gateways come
+// from the adapter table on Windows and from /proc/net/route and
+// /proc/net/ipv6_route elsewhere, so they stay empty on systems without
+// procfs.
+func LocalNetworks() ([]LocalNetwork, error) {
+	netInterfaces, err := net.Interfaces()
+	if err != nil {
+		return nil, err
+	}
+	gateways := defaultGateways()
+	var nets []LocalNetwork
+	for _, netInterface := range netInterfaces {
+		if netInterface.Flags&net.FlagUp == 0 || netInterface.Flags&net.FlagLoopback != 0 {
+			continue
+		}
+		addrs, err := netInterface.Addrs()
+		if err != nil {
+			return nil, err
+		}
+		n := LocalNetwork{
+			Name:     netInterface.Name,
+			MAC:      netInterface.HardwareAddr.String(),
+			MTU:      netInterface.MTU,
+			Gateways: gateways[netInterface.Name],
+		}
+		for _, address := range addrs {
+			if ipnet, ok := address.(*net.IPNet); ok {
+				n.Prefixes = append(n.Prefixes, ipnet)
+			}
+		}
+		nets = append(nets, n)
+	}
+	return nets, nil
+}
+
+// LocalTargetSet returns the hosts of every IPv4 prefix of nets, without
+// network and broadcast addresses. Link-local and IPv6 prefixes are left
+// out since they are too large to sweep.
+func LocalTargetSet(nets []LocalNetwork) *IPSet {
+	s := new(IPSet)
+	for _, n := range nets {
+		for _, prefix := range n.Prefixes {
+			if prefix.IP.To4() == nil || prefix.IP.IsLinkLocalUnicast() {
+				continue
+			}
+			s.addRange(prefixBounds(prefix, true))
+		}
+	}
+	return s
+}
+
+// LocalTargets iterates the hosts of the local IPv4 networks for
+// "scan my own LAN" jobs.
+func LocalTargets() (Iterator, error) {
+	nets, err := LocalNetworks()
+	if err != nil {
+		return nil, err
+	}
+	return LocalTargetSet(nets).Iter(), nil
+}
@@ -0,0 +1,59 @@
+//go:build !windows
+// +build !windows
+
+package tools
+
+import (
+	"bufio"
+	"encoding/binary"
+	"encoding/hex"
+	"net"
+	"os"
+	"strconv"
+	"strings"
+)
+
+// defaultGateways maps interface names to their default gateways as listed
+// by procfs; it is empty on platforms without it.
+func defaultGateways() map[string][]net.IP {
+	gateways := make(map[string][]net.IP)
+	readRouteTable("/proc/net/route", func(fields []string) {
+		// Iface Destination Gateway Flags RefCnt Use Metric Mask ...
+		if len(fields) < 8 || fields[1] != "00000000" || fields[7] != "00000000" {
+			return
+		}
+		gw, err := strconv.ParseUint(fields[2], 16, 32)
+		if err != nil || gw == 0 {
+			return
+		}
+		// the kernel prints addresses in host byte order, little endian on
+		// every platform we run on
+		ip := make(net.IP, net.IPv4len)
+		binary.LittleEndian.PutUint32(ip, uint32(gw))
+		gateways[fields[0]] = append(gateways[fields[0]], ip)
+	})
+	readRouteTable("/proc/net/ipv6_route", func(fields []string) {
+		// dest plen src plen nexthop metric refcnt use flags iface
+		if len(fields) < 10 || strings.Trim(fields[0], "0") != "" || fields[1] != "00" {
+			return
+		}
+		gw, err := hex.DecodeString(fields[4])
+		if err != nil || len(gw) != net.IPv6len || net.IP(gw).IsUnspecified() {
+			return
+		}
+		gateways[fields[9]] = append(gateways[fields[9]], net.IP(gw))
+	})
+	return gateways
+}
+
+func readRouteTable(path string, fn func(fields []string)) {
+	f, err := os.Open(path)
+	if err != nil {
+		return
+	}
+	defer f.Close()
+	scanner := bufio.NewScanner(f)
+	for scanner.Scan() {
+		fn(strings.Fields(scanner.Text()))
+	}
+}
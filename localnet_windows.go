@@ -0,0 +1,85 @@
+package tools
+
+import (
+	"net"
+	"os"
+	"syscall"
+	"unsafe"
+
+	"golang.org/x/sys/windows"
+)
+
+const gaaFlagIncludeGateways = 0x80 // GAA_FLAG_INCLUDE_GATEWAYS
+
+// ipAdapterAddresses extends windows.IpAdapterAddresses, which stops at
+// FirstPrefix, with the fields of IP_ADAPTER_ADDRESSES_LH up to the
+// gateway list.
+type ipAdapterAddresses struct {
+	windows.IpAdapterAddresses
+	TransmitLinkSpeed      uint64
+	ReceiveLinkSpeed       uint64
+	FirstWinsServerAddress *ipAdapterGatewayAddress
+	FirstGatewayAddress    *ipAdapterGatewayAddress
+}
+
+// ipAdapterGatewayAddress is IP_ADAPTER_GATEWAY_ADDRESS_LH.
+type ipAdapterGatewayAddress struct {
+	Length   uint32
+	Reserved uint32
+	Next     *ipAdapterGatewayAddress
+	Address  windows.SocketAddress
+}
+
+// adapterAddresses returns the adapters that are up, with their DNS
+// servers, prefixes and gateways.
+func adapterAddresses() ([]*ipAdapterAddresses, error) {
+	size := uint32(15000)
+	for {
+		buf := make([]byte, size)
+		aa := (*windows.IpAdapterAddresses)(unsafe.Pointer(&buf[0]))
+		err := windows.GetAdaptersAddresses(syscall.AF_UNSPEC, windows.GAA_FLAG_INCLUDE_PREFIX|gaaFlagIncludeGateways, 0, aa, &size)
+		if err == windows.ERROR_BUFFER_OVERFLOW {
+			continue
+		}
+		if err != nil {
+			return nil, os.NewSyscallError("getadaptersaddresses", err)
+		}
+		var adapters []*ipAdapterAddresses
+		for ; aa != nil; aa = aa.Next {
+			if aa.OperStatus == windows.IfOperStatusUp {
+				adapters = append(adapters, (*ipAdapterAddresses)(unsafe.Pointer(aa)))
+			}
+		}
+		return adapters, nil
+	}
+}
+
+// defaultGateways maps interface names, as net.Interfaces reports them, to
+// their default gateways.
+func defaultGateways() map[string][]net.IP {
+	gateways := make(map[string][]net.IP)
+	adapters, err := adapterAddresses()
+	if err != nil {
+		return gateways
+	}
+	for _, aa := range adapters {
+		name := utf16PtrToString(aa.FriendlyName)
+		for gw := aa.FirstGatewayAddress; gw != nil; gw = gw.Next {
+			if ip := gw.Address.IP(); ip != nil && !ip.IsUnspecified() {
+				gateways[name] = append(gateways[name], ip)
+			}
+		}
+	}
+	return gateways
+}
+
+func utf16PtrToString(p *uint16) string {
+	if p == nil {
+		return ""
+	}
+	var s []uint16
+	for ptr := unsafe.Pointer(p); *(*uint16)(ptr) != 0; ptr = unsafe.Pointer(uintptr(ptr) + 2) {
+		s = append(s, *(*uint16)(ptr))
+	}
+	return windows.UTF16ToString(s)
+}
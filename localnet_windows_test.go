@@ -0,0 +1,18 @@
+package tools
+
+import (
+	"testing"
+	"unsafe"
+)
+
+func TestIPAdapterAddressesLayout(t *testing.T) {
+	// offsets of FirstGatewayAddress in IP_ADAPTER_ADDRESSES_LH
+	want := uintptr(208)
+	if unsafe.Sizeof(uintptr(0)) == 4 {
+		want = 164
+	}
+	var aa ipAdapterAddresses
+	if got := unsafe.Offsetof(aa.FirstGatewayAddress); got != want {
+		t.Fatalf("FirstGatewayAddress at offset %d, want %d", got, want)
+	}
+}
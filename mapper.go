@@ -0,0 +1,94 @@
+package tools
+
+import "context"
+
+// MapFunc handles a single input of Map.
+type MapFunc func(ctx context.Context, input string) (interface{}, error)
+
+// MapResult is the outcome of one input of Map; Index is its position in
+// the source iterator.
+type MapResult struct {
+	Index int
+	Input string
+	Value interface{}
+	Err   error
+}
+
+// MapOptions tunes Map. Buffer caps how many inputs may be in flight or
+// waiting for an earlier one in Ordered mode; it defaults to 2*Workers.
+type MapOptions struct {
+	Workers int
+	Ordered bool
+	Buffer  int
+}
+
+// Map runs fn over every input of it on a WorkPool and streams the results.
+// Results come in completion order, or in input order with Ordered. Read the
+// channel until it is closed or cancel ctx; inputs are pulled from it only
+// as fast as results are consumed.
+func Map(ctx context.Context, it Iterator, fn MapFunc, opt MapOptions) <-chan MapResult {
+	if opt.Workers < 1 {
+		opt.Workers = 1
+	}
+	if opt.Buffer < opt.Workers {
+		opt.Buffer = 2 * opt.Workers
+	}
+	pool := NewWorkPool(ctx, opt.Workers)
+	slots := make(chan struct{}, opt.Buffer)
+	done := make(chan MapResult, opt.Buffer)
+	out := make(chan MapResult)
+
+	go func() {
+		defer close(done)
+		for i := 0; ; i++ {
+			input, ok := it.Next()
+			if !ok {
+				break
+			}
+			select {
+			case slots <- struct{}{}:
+			case <-pool.Context().Done():
+			}
+			if pool.Context().Err() != nil {
+				break
+			}
+			i := i
+			pool.Submit(func(ctx context.Context) error {
+				var v interface{}
+				err := Safe(func() (err error) {
+					v, err = fn(ctx, input)
+					return err
+				})
+				done <- MapResult{Index: i, Input: input, Value: v, Err: err}
+				return nil
+			})
+		}
+		pool.Wait()
+	}()
+
+	go func() {
+		defer close(out)
+		emit := func(r MapResult) {
+			select {
+			case out <- r:
+			case <-ctx.Done():
+			}
+			<-slots
+		}
+		held := make(map[int]MapResult)
+		next := 0
+		for r := range done {
+			if !opt.Ordered {
+				emit(r)
+				continue
+			}
+			held[r.Index] = r
+			for r, ok := held[next]; ok; r, ok = held[next] {
+				delete(held, next)
+				emit(r)
+				next++
+			}
+		}
+	}()
+	return out
+}
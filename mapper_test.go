@@ -0,0 +1,73 @@
+package tools
+
+import (
+	"context"
+	"fmt"
+	"strconv"
+	"testing"
+	"time"
+)
+
+func TestMapOrdered(t *testing.T) {
+	inputs := make([]string, 20)
+	for i := range inputs {
+		inputs[i] = strconv.Itoa(i)
+	}
+	// later inputs finish first
+	fn := func(ctx context.Context, input string) (interface{}, error) {
+		n, _ := strconv.Atoi(input)
+		time.Sleep(time.Duration(20-n) * time.Millisecond)
+		if n == 7 {
+			return nil, fmt.Errorf("bad input %d", n)
+		}
+		return n * n, nil
+	}
+	next, outOfOrder := 0, false
+	for res := range Map(context.Background(), SliceIterator(inputs), fn, MapOptions{Workers: 8, Ordered: true}) {
+		if res.Index != next || res.Input != inputs[next] {
+			t.Fatalf("got result %d (%s), want %d", res.Index, res.Input, next)
+		}
+		if next == 7 {
+			if res.Err == nil {
+				t.Fatal("error of input 7 lost")
+			}
+		} else if res.Err != nil || res.Value != next*next {
+			t.Fatalf("result %d = %v, %v", next, res.Value, res.Err)
+		}
+		next++
+	}
+	if next != len(inputs) {
+		t.Fatalf("%d results, want %d", next, len(inputs))
+	}
+
+	seen := make(map[int]bool)
+	last := -1
+	for res := range Map(context.Background(), SliceIterator(inputs), fn, MapOptions{Workers: 8}) {
+		if res.Index < last {
+			outOfOrder = true
+		}
+		last = res.Index
+		seen[res.Index] = true
+	}
+	if len(seen) != len(inputs) || !outOfOrder {
+		t.Fatalf("unordered Map: %d results, out of order %v", len(seen), outOfOrder)
+	}
+}
+
+func TestMapPanic(t *testing.T) {
+	fn := func(ctx context.Context, input string) (interface{}, error) {
+		if input == "b" {
+			panic("boom")
+		}
+		return input, nil
+	}
+	var errs int
+	for res := range Map(context.Background(), SliceIterator([]string{"a", "b", "c"}), fn, MapOptions{Ordered: true}) {
+		if _, ok := res.Err.(*PanicError); ok {
+			errs++
+		}
+	}
+	if errs != 1 {
+		t.Fatalf("%d panics reported, want 1", errs)
+	}
+}
@@ -0,0 +1,310 @@
+package tools
+
+import (
+	"bytes"
+	"fmt"
+	"io"
+	"math"
+	"net"
+	"net/http"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+	"sync/atomic"
+	"time"
+)
+
+// DefaultBuckets are latency histogram bounds in seconds.
+var DefaultBuckets = []float64{.005, .01, .025, .05, .1, .25, .5, 1, 2.5, 5, 10}
+
+// DefaultRegistry collects the metrics of HTTPClient and of the pools
+// instrumented without a registry of their own.
+var DefaultRegistry = NewRegistry()
+
+// HTTPClient is used by Post_json; its requests are counted and timed in
+// DefaultRegistry.
+var HTTPClient = &http.Client{Transport: InstrumentTransport(http.DefaultTransport, DefaultRegistry)}
+
+// Counter is a value that only goes up.
+type Counter struct{ bits uint64 }
+
+func (c *Counter) Inc() { c.Add(1) }
+
+// Add adds v, which must not be negative.
+func (c *Counter) Add(v float64) { addFloat(&c.bits, v) }
+
+func (c *Counter) Value() float64 { return math.Float64frombits(atomic.LoadUint64(&c.bits)) }
+
+// Gauge is a value that goes up and down.
+type Gauge struct{ bits uint64 }
+
+func (g *Gauge) Set(v float64)  { atomic.StoreUint64(&g.bits, math.Float64bits(v)) }
+func (g *Gauge) Add(v float64)  { addFloat(&g.bits, v) }
+func (g *Gauge) Inc()           { g.Add(1) }
+func (g *Gauge) Dec()           { g.Add(-1) }
+func (g *Gauge) Value() float64 { return math.Float64frombits(atomic.LoadUint64(&g.bits)) }
+
+func addFloat(bits *uint64, v float64) {
+	for {
+		old := atomic.LoadUint64(bits)
+		if atomic.CompareAndSwapUint64(bits, old, math.Float64bits(math.Float64frombits(old)+v)) {
+			return
+		}
+	}
+}
+
+type gaugeFunc func() float64
+
+// Histogram counts observations, e.g. latencies, in cumulative buckets.
+type Histogram struct {
+	mu     sync.Mutex
+	bounds []float64
+	counts []uint64 // per bucket, the last one is +Inf
+	sum    float64
+	count  uint64
+}
+
+func (h *Histogram) Observe(v float64) {
+	i := sort.SearchFloat64s(h.bounds, v)
+	h.mu.Lock()
+	h.counts[i]++
+	h.sum += v
+	h.count++
+	h.mu.Unlock()
+}
+
+// ObserveDuration records d in seconds.
+func (h *Histogram) ObserveDuration(d time.Duration) { h.Observe(d.Seconds()) }
+
+//================================================================================
+
+// Registry holds metrics and renders them in the Prometheus text format.
+// It is an http.Handler, so it can be mounted on any mux as /metrics.
+type Registry struct {
+	mu       sync.Mutex
+	families map[string]*metricFamily
+}
+
+type metricFamily struct {
+	name, help, kind string
+	metrics          map[string]interface{} // by rendered label set
+}
+
+func NewRegistry() *Registry {
+	return &Registry{families: make(map[string]*metricFamily)}
+}
+
+// Counter returns the counter name with the given label pairs ("code",
+// "200", ...), creating it on first use.
+func (r *Registry) Counter(name, help string, labels ...string) *Counter {
+	return r.metric(name, help, "counter", labels, func() interface{} { return new(Counter) }).(*Counter)
+}
+
+// Gauge returns the gauge name with the given label pairs.
+func (r *Registry) Gauge(name, help string, labels ...string) *Gauge {
+	return r.metric(name, help, "gauge", labels, func() interface{} { return new(Gauge) }).(*Gauge)
+}
+
+// GaugeFunc exports a gauge whose value is read from fn at scrape time.
+// Registering the same name and labels again replaces fn.
+func (r *Registry) GaugeFunc(name, help string, fn func() float64, labels ...string) {
+	if _, ok := r.metric(name, help, "gauge", labels, func() interface{} { return gaugeFunc(fn) }).(gaugeFunc); !ok {
+		panic("metrics: " + name + " registered as a Gauge and a GaugeFunc")
+	}
+	r.mu.Lock()
+	r.families[name].metrics[renderLabels(labels)] = gaugeFunc(fn)
+	r.mu.Unlock()
+}
+
+// Histogram returns the histogram name with the given bucket upper bounds,
+// which must be sorted, and label pairs.
+func (r *Registry) Histogram(name, help string, buckets []float64, labels ...string) *Histogram {
+	return r.metric(name, help, "histogram", labels, func() interface{} {
+		return &Histogram{bounds: buckets, counts: make([]uint64, len(buckets)+1)}
+	}).(*Histogram)
+}
+
+func (r *Registry) metric(name, help, kind string, labels []string, create func() interface{}) interface{} {
+	if len(labels)%2 != 0 {
+		panic("metrics: odd number of label arguments for " + name)
+	}
+	key := renderLabels(labels)
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	f, ok := r.families[name]
+	if !ok {
+		f = &metricFamily{name: name, help: help, kind: kind, metrics: make(map[string]interface{})}
+		r.families[name] = f
+	} else if f.kind != kind {
+		panic(fmt.Sprintf("metrics: %s registered as %s and %s", name, f.kind, kind))
+	}
+	m, ok := f.metrics[key]
+	if !ok {
+		m = create()
+		f.metrics[key] = m
+	}
+	return m
+}
+
+func renderLabels(labels []string) string {
+	if len(labels) == 0 {
+		return ""
+	}
+	pairs := make([]string, 0, len(labels)/2)
+	for i := 0; i < len(labels); i += 2 {
+		pairs = append(pairs, labels[i]+`="`+labelEscaper.Replace(labels[i+1])+`"`)
+	}
+	return "{" + strings.Join(pairs, ",") + "}"
+}
+
+var (
+	labelEscaper = strings.NewReplacer(`\`, `\\`, `"`, `\"`, "\n", `\n`)
+	helpEscaper  = strings.NewReplacer(`\`, `\\`, "\n", `\n`)
+)
+
+func formatFloat(v float64) string {
+	switch {
+	case math.IsInf(v, 1):
+		return "+Inf"
+	case math.IsInf(v, -1):
+		return "-Inf"
+	}
+	return strconv.FormatFloat(v, 'g', -1, 64)
+}
+
+// withLabel adds one more label to a rendered label set.
+func withLabel(labels, name, value string) string {
+	pair := name + `="` + value + `"`
+	if labels == "" {
+		return "{" + pair + "}"
+	}
+	return labels[:len(labels)-1] + "," + pair + "}"
+}
+
+// WriteTo writes every metric in the Prometheus text exposition format.
+func (r *Registry) WriteTo(w io.Writer) (int64, error) {
+	r.mu.Lock()
+	names := make([]string, 0, len(r.families))
+	for name := range r.families {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	var buf bytes.Buffer
+	for _, name := range names {
+		f := r.families[name]
+		fmt.Fprintf(&buf, "# HELP %s %s\n# TYPE %s %s\n", name, helpEscaper.Replace(f.help), name, f.kind)
+		keys := make([]string, 0, len(f.metrics))
+		for k := range f.metrics {
+			keys = append(keys, k)
+		}
+		sort.Strings(keys)
+		for _, labels := range keys {
+			switch m := f.metrics[labels].(type) {
+			case *Counter:
+				fmt.Fprintf(&buf, "%s%s %s\n", name, labels, formatFloat(m.Value()))
+			case *Gauge:
+				fmt.Fprintf(&buf, "%s%s %s\n", name, labels, formatFloat(m.Value()))
+			case gaugeFunc:
+				fmt.Fprintf(&buf, "%s%s %s\n", name, labels, formatFloat(m()))
+			case *Histogram:
+				m.mu.Lock()
+				var cum uint64
+				for i, n := range m.counts {
+					cum += n
+					le := math.Inf(1)
+					if i < len(m.bounds) {
+						le = m.bounds[i]
+					}
+					fmt.Fprintf(&buf, "%s_bucket%s %d\n", name, withLabel(labels, "le", formatFloat(le)), cum)
+				}
+				fmt.Fprintf(&buf, "%s_sum%s %s\n%s_count%s %d\n", name, labels, formatFloat(m.sum), name, labels, m.count)
+				m.mu.Unlock()
+			}
+		}
+	}
+	r.mu.Unlock()
+	return buf.WriteTo(w)
+}
+
+func (r *Registry) ServeHTTP(w http.ResponseWriter, req *http.Request) {
+	w.Header().Set("Content-Type", "text/plain; version=0.0.4; charset=utf-8")
+	r.WriteTo(w)
+}
+
+// ServeMetrics serves r on addr at /metrics in the background. The
+// returned server's Addr is the address actually bound, so ":0" works;
+// Close it to stop serving.
+func ServeMetrics(addr string, r *Registry) (*http.Server, error) {
+	ln, err := net.Listen("tcp", addr)
+	if err != nil {
+		return nil, err
+	}
+	mux := http.NewServeMux()
+	mux.Handle("/metrics", r)
+	srv := &http.Server{Addr: ln.Addr().String(), Handler: mux}
+	go srv.Serve(ln)
+	return srv, nil
+}
+
+//================================================================================
+
+// Instrument exports the state and results of p to r with the label
+// pool=name: queued, delayed and running tasks, the worker limit, finished
+// tasks by result and the task duration. Instrumenting another pool under
+// the same name moves the gauges over to it; the counters and the
+// histogram keep adding up across both.
+func (p *WorkPool) Instrument(r *Registry, name string) {
+	if r == nil {
+		r = DefaultRegistry
+	}
+	r.GaugeFunc("tools_pool_queued_tasks", "Tasks waiting for a worker.", func() float64 { return float64(p.Queued()) }, "pool", name)
+	r.GaugeFunc("tools_pool_delayed_tasks", "Tasks scheduled for later.", func() float64 { return float64(p.Delayed()) }, "pool", name)
+	r.GaugeFunc("tools_pool_running_tasks", "Tasks running right now.", func() float64 { return float64(p.Running()) }, "pool", name)
+	r.GaugeFunc("tools_pool_workers", "Concurrency limit of the pool.", func() float64 { return float64(p.Workers()) }, "pool", name)
+	r.GaugeFunc("tools_pool_skipped_tasks", "Tasks skipped because the checkpoint lists them as done.", func() float64 { return float64(p.Skipped()) }, "pool", name)
+	done := r.Counter("tools_pool_tasks_total", "Finished tasks by result.", "pool", name, "result", "done")
+	failed := r.Counter("tools_pool_tasks_total", "Finished tasks by result.", "pool", name, "result", "failed")
+	duration := r.Histogram("tools_pool_task_duration_seconds", "Time from start to end of a task, retries included.", DefaultBuckets, "pool", name)
+	p.OnResult(func(res TaskResult) {
+		if res.Err != nil {
+			failed.Inc()
+		} else {
+			done.Inc()
+		}
+		duration.ObserveDuration(res.Duration)
+	})
+}
+
+type instrumentedTransport struct {
+	next     http.RoundTripper
+	reg      *Registry
+	inFlight *Gauge
+}
+
+// InstrumentTransport counts and times the requests made through next in r,
+// by method and status code ("error" when no response came back).
+func InstrumentTransport(next http.RoundTripper, r *Registry) http.RoundTripper {
+	if next == nil {
+		next = http.DefaultTransport
+	}
+	return &instrumentedTransport{
+		next:     next,
+		reg:      r,
+		inFlight: r.Gauge("tools_http_requests_in_flight", "Outgoing HTTP requests waiting for a response."),
+	}
+}
+
+func (t *instrumentedTransport) RoundTrip(req *http.Request) (*http.Response, error) {
+	t.inFlight.Inc()
+	start := time.Now()
+	resp, err := t.next.RoundTrip(req)
+	t.inFlight.Dec()
+	code := "error"
+	if err == nil {
+		code = strconv.Itoa(resp.StatusCode)
+	}
+	t.reg.Counter("tools_http_requests_total", "Outgoing HTTP requests by method and status code.", "method", req.Method, "code", code).Inc()
+	t.reg.Histogram("tools_http_request_duration_seconds", "Time until the response headers of outgoing HTTP requests arrived.", DefaultBuckets, "method", req.Method).ObserveDuration(time.Since(start))
+	return resp, err
+}
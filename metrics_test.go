@@ -0,0 +1,69 @@
+package tools
+
+import (
+	"bytes"
+	"context"
+	"strings"
+	"testing"
+)
+
+func TestRegistryExposition(t *testing.T) {
+	r := NewRegistry()
+	r.Counter("jobs_total", "Finished jobs.", "result", "done").Add(3)
+	r.Counter("jobs_total", "Finished jobs.", "result", "failed").Inc()
+	g := r.Gauge("queue_depth", "Jobs waiting.\nPer pool.")
+	g.Set(5)
+	g.Dec()
+	h := r.Histogram("latency_seconds", "Job latency.", []float64{0.1, 1}, "path", `a"b`)
+	for _, v := range []float64{0.05, 0.5, 0.5, 3} {
+		h.Observe(v)
+	}
+
+	var buf bytes.Buffer
+	if _, err := r.WriteTo(&buf); err != nil {
+		t.Fatal(err)
+	}
+	want := `# HELP jobs_total Finished jobs.
+# TYPE jobs_total counter
+jobs_total{result="done"} 3
+jobs_total{result="failed"} 1
+# HELP latency_seconds Job latency.
+# TYPE latency_seconds histogram
+latency_seconds_bucket{path="a\"b",le="0.1"} 1
+latency_seconds_bucket{path="a\"b",le="1"} 3
+latency_seconds_bucket{path="a\"b",le="+Inf"} 4
+latency_seconds_sum{path="a\"b"} 4.05
+latency_seconds_count{path="a\"b"} 4
+# HELP queue_depth Jobs waiting.\nPer pool.
+# TYPE queue_depth gauge
+queue_depth 4
+`
+	if buf.String() != want {
+		t.Fatalf("exposition:\n%s\nwant:\n%s", buf.String(), want)
+	}
+}
+
+func TestInstrumentSameNameTwice(t *testing.T) {
+	r := NewRegistry()
+	first := NewWorkPool(context.Background(), 2)
+	first.Instrument(r, "scan")
+	first.Submit(func(ctx context.Context) error { return nil })
+	first.Wait()
+
+	second := NewWorkPool(context.Background(), 7)
+	second.Instrument(r, "scan")
+	second.Submit(func(ctx context.Context) error { return nil })
+	second.Wait()
+
+	var buf bytes.Buffer
+	r.WriteTo(&buf)
+	out := buf.String()
+	for _, line := range []string{
+		`tools_pool_workers{pool="scan"} 7`,
+		`tools_pool_tasks_total{pool="scan",result="done"} 2`,
+	} {
+		if !strings.Contains(out, line+"\n") {
+			t.Errorf("missing %q in\n%s", line, out)
+		}
+	}
+}
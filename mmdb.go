@@ -0,0 +1,463 @@
+package tools
+
+import (
+	"bytes"
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"io/ioutil"
+	"math"
+	"math/big"
+	"net"
+	"strconv"
+)
+
+var (
+	ErrBadMMDB = errors.New("invalid MaxMind DB file")
+
+	mmdbMetadataMarker = []byte("\xab\xcd\xefMaxMind.com")
+)
+
+// MMDBMetadata is the metadata block every MaxMind DB file ends with.
+type MMDBMetadata struct {
+	DatabaseType string
+	Description  map[string]interface{}
+	Languages    []string
+	IPVersion    int
+	NodeCount    uint
+	RecordSize   uint
+	BuildEpoch   uint64
+}
+
+// MMDB reads a MaxMind DB (.mmdb) file such as GeoLite2-City or GeoLite2-ASN.
+// The file is loaded into memory once; lookups are safe for concurrent use.
+type MMDB struct {
+	Metadata MMDBMetadata
+	Language string // preferred name language, "en" by default
+
+	buf       []byte
+	dataStart uint
+	ipv4Start uint
+}
+
+// OpenMMDB loads and validates a .mmdb file.
+func OpenMMDB(path string) (*MMDB, error) {
+	buf, err := ioutil.ReadFile(path)
+	if err != nil {
+		return nil, err
+	}
+	return NewMMDB(buf)
+}
+
+// NewMMDB parses a MaxMind DB held in memory.
+func NewMMDB(buf []byte) (*MMDB, error) {
+	i := bytes.LastIndex(buf, mmdbMetadataMarker)
+	if i < 0 {
+		return nil, fmt.Errorf("%w: metadata not found", ErrBadMMDB)
+	}
+	metaStart := uint(i + len(mmdbMetadataMarker))
+	meta := mmdbDecoder{buf: buf[metaStart:]}
+	v, _, err := meta.decode(0)
+	if err != nil {
+		return nil, err
+	}
+	m, ok := v.(map[string]interface{})
+	if !ok {
+		return nil, fmt.Errorf("%w: metadata is not a map", ErrBadMMDB)
+	}
+	db := &MMDB{Language: "en", buf: buf}
+	db.Metadata = MMDBMetadata{
+		DatabaseType: mmdbString(m["database_type"]),
+		IPVersion:    int(mmdbUint(m["ip_version"])),
+		NodeCount:    uint(mmdbUint(m["node_count"])),
+		RecordSize:   uint(mmdbUint(m["record_size"])),
+		BuildEpoch:   mmdbUint(m["build_epoch"]),
+	}
+	db.Metadata.Description, _ = m["description"].(map[string]interface{})
+	if langs, ok := m["languages"].([]interface{}); ok {
+		for _, l := range langs {
+			db.Metadata.Languages = append(db.Metadata.Languages, mmdbString(l))
+		}
+	}
+	switch db.Metadata.RecordSize {
+	case 24, 28, 32:
+	default:
+		return nil, fmt.Errorf("%w: unsupported record size %d", ErrBadMMDB, db.Metadata.RecordSize)
+	}
+	treeSize := db.Metadata.RecordSize * 2 / 8 * db.Metadata.NodeCount
+	db.dataStart = treeSize + 16
+	if db.dataStart > metaStart {
+		return nil, fmt.Errorf("%w: search tree overruns the file", ErrBadMMDB)
+	}
+
+	// IPv4 addresses live under ::/96 in an IPv6 tree
+	if db.Metadata.IPVersion == 6 {
+		node := uint(0)
+		for i := 0; i < 96 && node < db.Metadata.NodeCount; i++ {
+			node = db.record(node, 0)
+		}
+		db.ipv4Start = node
+	}
+	return db, nil
+}
+
+// record reads the left (bit 0) or right (bit 1) record of a tree node.
+func (db *MMDB) record(node, bit uint) uint {
+	size := db.Metadata.RecordSize
+	b := db.buf[node*size/4:]
+	switch size {
+	case 24:
+		b = b[bit*3:]
+		return uint(b[0])<<16 | uint(b[1])<<8 | uint(b[2])
+	case 28:
+		if bit == 0 {
+			return uint(b[3]&0xf0)<<20 | uint(b[0])<<16 | uint(b[1])<<8 | uint(b[2])
+		}
+		return uint(b[3]&0x0f)<<24 | uint(b[4])<<16 | uint(b[5])<<8 | uint(b[6])
+	}
+	return uint(binary.BigEndian.Uint32(b[bit*4:]))
+}
+
+// Lookup returns the raw record for ip, or nil when the database has none.
+func (db *MMDB) Lookup(ip net.IP) (map[string]interface{}, error) {
+	node := uint(0)
+	if v4 := ip.To4(); v4 != nil {
+		ip = v4
+		if db.Metadata.IPVersion == 6 {
+			node = db.ipv4Start
+		}
+	} else if ip = ip.To16(); ip == nil {
+		return nil, ErrBadAddress
+	} else if db.Metadata.IPVersion == 4 {
+		return nil, fmt.Errorf("%w: IPv6 lookup in an IPv4 database", ErrBadAddress)
+	}
+
+	count := db.Metadata.NodeCount
+	for i := 0; i < len(ip)*8 && node < count; i++ {
+		bit := uint(ip[i/8]>>(7-uint(i%8))) & 1
+		node = db.record(node, bit)
+	}
+	if node == count {
+		return nil, nil
+	}
+	if node < count {
+		return nil, fmt.Errorf("%w: search tree ends inside a node", ErrBadMMDB)
+	}
+	d := mmdbDecoder{buf: db.buf[db.dataStart:]}
+	v, _, err := d.decode(node - count - 16)
+	if err != nil {
+		return nil, err
+	}
+	m, _ := v.(map[string]interface{})
+	return m, nil
+}
+
+//================================================================================
+
+// GeoInfo is the location and network owner of an address. Fill it from a
+// City or Country database and an ASN database with Enrich.
+type GeoInfo struct {
+	IP          string
+	CountryCode string
+	Country     string
+	City        string
+	Latitude    float64
+	Longitude   float64
+	ASN         uint
+	Org         string
+}
+
+// GeoCSVHeader names the columns of GeoInfo.CSV.
+var GeoCSVHeader = []string{"ip", "country_code", "country", "city", "latitude", "longitude", "asn", "org"}
+
+// CSV returns the fields of g in GeoCSVHeader order, blank when unknown.
+func (g GeoInfo) CSV() []string {
+	row := []string{g.IP, g.CountryCode, g.Country, g.City, "", "", "", g.Org}
+	if g.Latitude != 0 || g.Longitude != 0 {
+		row[4] = strconv.FormatFloat(g.Latitude, 'f', -1, 64)
+		row[5] = strconv.FormatFloat(g.Longitude, 'f', -1, 64)
+	}
+	if g.ASN != 0 {
+		row[6] = strconv.FormatUint(uint64(g.ASN), 10)
+	}
+	return row
+}
+
+// fill copies the fields db knows about into g.
+func (db *MMDB) fill(g *GeoInfo, ip net.IP) error {
+	m, err := db.Lookup(ip)
+	if err != nil || m == nil {
+		return err
+	}
+	country := mmdbMap(m["country"])
+	if country == nil {
+		country = mmdbMap(m["registered_country"])
+	}
+	if country != nil {
+		g.CountryCode = mmdbString(country["iso_code"])
+		g.Country = db.name(country)
+	}
+	if city := mmdbMap(m["city"]); city != nil {
+		g.City = db.name(city)
+	}
+	if loc := mmdbMap(m["location"]); loc != nil {
+		g.Latitude, _ = loc["latitude"].(float64)
+		g.Longitude, _ = loc["longitude"].(float64)
+	}
+	if asn := mmdbUint(m["autonomous_system_number"]); asn != 0 {
+		g.ASN = uint(asn)
+		g.Org = mmdbString(m["autonomous_system_organization"])
+	}
+	return nil
+}
+
+func (db *MMDB) name(m map[string]interface{}) string {
+	names := mmdbMap(m["names"])
+	if s := mmdbString(names[db.Language]); s != "" {
+		return s
+	}
+	return mmdbString(names["en"])
+}
+
+// Enrich looks ip up in every database, later ones filling in what earlier
+// ones did not know.
+func Enrich(ip string, dbs ...*MMDB) (GeoInfo, error) {
+	g := GeoInfo{IP: ip}
+	addr := net.ParseIP(ip)
+	if addr == nil {
+		return g, fmt.Errorf("%w: %q", ErrBadAddress, ip)
+	}
+	for _, db := range dbs {
+		var part GeoInfo
+		if err := db.fill(&part, addr); err != nil {
+			return g, err
+		}
+		mergeGeoInfo(&g, part)
+	}
+	return g, nil
+}
+
+func mergeGeoInfo(g *GeoInfo, part GeoInfo) {
+	if g.CountryCode == "" {
+		g.CountryCode, g.Country = part.CountryCode, part.Country
+	}
+	if g.City == "" {
+		g.City = part.City
+	}
+	if g.Latitude == 0 && g.Longitude == 0 {
+		g.Latitude, g.Longitude = part.Latitude, part.Longitude
+	}
+	if g.ASN == 0 {
+		g.ASN, g.Org = part.ASN, part.Org
+	}
+}
+
+// EnrichAll runs Enrich over every address of it; hostnames and lookup
+// failures are passed to fn with their error.
+func EnrichAll(it Iterator, fn func(GeoInfo, error), dbs ...*MMDB) {
+	for ip, ok := it.Next(); ok; ip, ok = it.Next() {
+		fn(Enrich(ip, dbs...))
+	}
+}
+
+//================================================================================
+
+// mmdbDecoder decodes the MaxMind DB data section format.
+type mmdbDecoder struct {
+	buf []byte
+}
+
+const (
+	mmdbTypePointer   = 1
+	mmdbTypeString    = 2
+	mmdbTypeDouble    = 3
+	mmdbTypeBytes     = 4
+	mmdbTypeUint16    = 5
+	mmdbTypeUint32    = 6
+	mmdbTypeMap       = 7
+	mmdbTypeInt32     = 8
+	mmdbTypeUint64    = 9
+	mmdbTypeUint128   = 10
+	mmdbTypeArray     = 11
+	mmdbTypeContainer = 12
+	mmdbTypeEndMarker = 13
+	mmdbTypeBool      = 14
+	mmdbTypeFloat     = 15
+)
+
+func (d *mmdbDecoder) errorf(format string, args ...interface{}) error {
+	return fmt.Errorf("%w: %s", ErrBadMMDB, fmt.Sprintf(format, args...))
+}
+
+func (d *mmdbDecoder) take(off, n uint) ([]byte, error) {
+	if off+n > uint(len(d.buf)) || off+n < off {
+		return nil, d.errorf("data at %d overruns the section", off)
+	}
+	return d.buf[off : off+n], nil
+}
+
+// decode returns the value at off and the offset right after it.
+func (d *mmdbDecoder) decode(off uint) (interface{}, uint, error) {
+	return d.decodeDepth(off, 0)
+}
+
+func (d *mmdbDecoder) decodeDepth(off uint, depth int) (interface{}, uint, error) {
+	if depth > 64 {
+		return nil, 0, d.errorf("data nested too deeply")
+	}
+	ctrl, err := d.take(off, 1)
+	if err != nil {
+		return nil, 0, err
+	}
+	off++
+	typ := uint(ctrl[0] >> 5)
+	if typ == mmdbTypePointer {
+		target, next, err := d.pointer(ctrl[0], off)
+		if err != nil {
+			return nil, 0, err
+		}
+		v, _, err := d.decodeDepth(target, depth+1)
+		return v, next, err
+	}
+	if typ == 0 {
+		ext, err := d.take(off, 1)
+		if err != nil {
+			return nil, 0, err
+		}
+		typ = 7 + uint(ext[0])
+		off++
+	}
+	size := uint(ctrl[0] & 0x1f)
+	if size >= 29 {
+		n := size - 28
+		b, err := d.take(off, n)
+		if err != nil {
+			return nil, 0, err
+		}
+		off += n
+		var extra uint
+		for _, c := range b {
+			extra = extra<<8 | uint(c)
+		}
+		size = []uint{29, 285, 65821}[n-1] + extra
+	}
+
+	switch typ {
+	case mmdbTypeMap:
+		m := make(map[string]interface{}, size)
+		for i := uint(0); i < size; i++ {
+			k, next, err := d.decodeDepth(off, depth+1)
+			if err != nil {
+				return nil, 0, err
+			}
+			key, ok := k.(string)
+			if !ok {
+				return nil, 0, d.errorf("map key at %d is not a string", off)
+			}
+			v, next, err := d.decodeDepth(next, depth+1)
+			if err != nil {
+				return nil, 0, err
+			}
+			m[key] = v
+			off = next
+		}
+		return m, off, nil
+	case mmdbTypeArray:
+		a := make([]interface{}, 0, size)
+		for i := uint(0); i < size; i++ {
+			v, next, err := d.decodeDepth(off, depth+1)
+			if err != nil {
+				return nil, 0, err
+			}
+			a = append(a, v)
+			off = next
+		}
+		return a, off, nil
+	case mmdbTypeBool:
+		return size != 0, off, nil
+	case mmdbTypeContainer, mmdbTypeEndMarker:
+		return nil, off, nil
+	}
+
+	b, err := d.take(off, size)
+	if err != nil {
+		return nil, 0, err
+	}
+	off += size
+	switch typ {
+	case mmdbTypeString:
+		return string(b), off, nil
+	case mmdbTypeBytes:
+		return append([]byte(nil), b...), off, nil
+	case mmdbTypeDouble:
+		if size != 8 {
+			return nil, 0, d.errorf("double of size %d", size)
+		}
+		return math.Float64frombits(binary.BigEndian.Uint64(b)), off, nil
+	case mmdbTypeFloat:
+		if size != 4 {
+			return nil, 0, d.errorf("float of size %d", size)
+		}
+		return float64(math.Float32frombits(binary.BigEndian.Uint32(b))), off, nil
+	case mmdbTypeUint16, mmdbTypeUint32, mmdbTypeUint64:
+		if size > 8 {
+			return nil, 0, d.errorf("integer of size %d", size)
+		}
+		var n uint64
+		for _, c := range b {
+			n = n<<8 | uint64(c)
+		}
+		return n, off, nil
+	case mmdbTypeInt32:
+		if size > 4 {
+			return nil, 0, d.errorf("int32 of size %d", size)
+		}
+		var n uint32
+		for _, c := range b {
+			n = n<<8 | uint32(c)
+		}
+		return int64(int32(n)), off, nil
+	case mmdbTypeUint128:
+		return new(big.Int).SetBytes(b), off, nil
+	}
+	return nil, 0, d.errorf("unknown data type %d at %d", typ, off-size)
+}
+
+// pointer decodes a pointer whose control byte is ctrl and whose payload
+// starts at off.
+func (d *mmdbDecoder) pointer(ctrl byte, off uint) (target, next uint, err error) {
+	n := uint(ctrl>>3)&0x3 + 1
+	b, err := d.take(off, n)
+	if err != nil {
+		return 0, 0, err
+	}
+	var p uint
+	if n < 4 {
+		p = uint(ctrl & 0x7)
+	}
+	for _, c := range b {
+		p = p<<8 | uint(c)
+	}
+	p += []uint{0, 2048, 526336, 0}[n-1]
+	return p, off + n, nil
+}
+
+func mmdbMap(v interface{}) map[string]interface{} {
+	m, _ := v.(map[string]interface{})
+	return m
+}
+
+func mmdbString(v interface{}) string {
+	s, _ := v.(string)
+	return s
+}
+
+func mmdbUint(v interface{}) uint64 {
+	switch n := v.(type) {
+	case uint64:
+		return n
+	case int64:
+		return uint64(n)
+	}
+	return 0
+}
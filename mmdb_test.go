@@ -0,0 +1,225 @@
+package tools
+
+import (
+	"encoding/binary"
+	"math"
+	"math/big"
+	"net"
+	"sort"
+	"testing"
+)
+
+// The helpers below write the MaxMind DB format by hand so the decoder is
+// tested without a real GeoLite2 file.
+
+func mmdbCtrl(typ, size int) []byte {
+	var ext []byte
+	if typ > 7 {
+		ext, typ = []byte{byte(typ - 7)}, 0
+	}
+	var b []byte
+	switch {
+	case size < 29:
+		b = []byte{byte(typ<<5 | size)}
+	case size < 285:
+		b = []byte{byte(typ<<5 | 29), byte(size - 29)}
+	default:
+		b = []byte{byte(typ<<5 | 30), byte((size - 285) >> 8), byte(size - 285)}
+	}
+	return append(append(b[:1:1], ext...), b[1:]...)
+}
+
+func mmdbStr(s string) []byte { return append(mmdbCtrl(mmdbTypeString, len(s)), s...) }
+
+func mmdbInt(typ int, v uint64) []byte {
+	var raw []byte
+	for ; v > 0; v >>= 8 {
+		raw = append([]byte{byte(v)}, raw...)
+	}
+	return append(mmdbCtrl(typ, len(raw)), raw...)
+}
+
+func mmdbDouble(f float64) []byte {
+	b := make([]byte, 8)
+	binary.BigEndian.PutUint64(b, math.Float64bits(f))
+	return append(mmdbCtrl(mmdbTypeDouble, 8), b...)
+}
+
+func mmdbObj(kv map[string][]byte) []byte {
+	keys := make([]string, 0, len(kv))
+	for k := range kv {
+		keys = append(keys, k)
+	}
+	sort.Strings(keys)
+	b := mmdbCtrl(mmdbTypeMap, len(kv))
+	for _, k := range keys {
+		b = append(b, mmdbStr(k)...)
+		b = append(b, kv[k]...)
+	}
+	return b
+}
+
+func mmdbArray(items ...[]byte) []byte {
+	b := mmdbCtrl(mmdbTypeArray, len(items))
+	for _, item := range items {
+		b = append(b, item...)
+	}
+	return b
+}
+
+// mmdbPtr is a two-byte pointer, which covers offsets 2048 to 526335.
+func mmdbPtr(off int) []byte {
+	off -= 2048
+	return []byte{byte(mmdbTypePointer<<5 | 1<<3 | (off>>16)&7), byte(off >> 8), byte(off)}
+}
+
+type mmdbTestNode struct {
+	kids [2]*mmdbTestNode
+	data int
+	leaf bool
+}
+
+// buildMMDB writes an IPv6 database mapping each prefix to an offset in data.
+func buildMMDB(prefixes map[string]int, data []byte, recordSize int) []byte {
+	root := &mmdbTestNode{}
+	for cidr, off := range prefixes {
+		_, n, _ := net.ParseCIDR(cidr)
+		ip := n.IP.To16()
+		ones, _ := n.Mask.Size()
+		if v4 := n.IP.To4(); v4 != nil {
+			ones += 96
+			ip = make(net.IP, net.IPv6len)
+			copy(ip[12:], v4)
+		}
+		cur := root
+		for i := 0; i < ones; i++ {
+			bit := ip[i/8] >> (7 - uint(i%8)) & 1
+			if cur.kids[bit] == nil {
+				cur.kids[bit] = &mmdbTestNode{}
+			}
+			cur = cur.kids[bit]
+		}
+		cur.leaf, cur.data = true, off
+	}
+	var nodes []*mmdbTestNode
+	index := make(map[*mmdbTestNode]int)
+	var walk func(n *mmdbTestNode)
+	walk = func(n *mmdbTestNode) {
+		if n == nil || n.leaf {
+			return
+		}
+		index[n] = len(nodes)
+		nodes = append(nodes, n)
+		walk(n.kids[0])
+		walk(n.kids[1])
+	}
+	walk(root)
+	count := len(nodes)
+
+	var out []byte
+	for _, n := range nodes {
+		var rec [2]uint32
+		for bit, k := range n.kids {
+			switch {
+			case k == nil:
+				rec[bit] = uint32(count)
+			case k.leaf:
+				rec[bit] = uint32(count + 16 + k.data)
+			default:
+				rec[bit] = uint32(index[k])
+			}
+		}
+		l, r := rec[0], rec[1]
+		switch recordSize {
+		case 24:
+			out = append(out, byte(l>>16), byte(l>>8), byte(l), byte(r>>16), byte(r>>8), byte(r))
+		case 28:
+			out = append(out, byte(l>>16), byte(l>>8), byte(l), byte(l>>24)<<4|byte(r>>24)&0xf, byte(r>>16), byte(r>>8), byte(r))
+		case 32:
+			b := make([]byte, 8)
+			binary.BigEndian.PutUint32(b, l)
+			binary.BigEndian.PutUint32(b[4:], r)
+			out = append(out, b...)
+		}
+	}
+	out = append(out, make([]byte, 16)...)
+	out = append(out, data...)
+	out = append(out, mmdbMetadataMarker...)
+	return append(out, mmdbObj(map[string][]byte{
+		"node_count":    mmdbInt(mmdbTypeUint32, uint64(count)),
+		"record_size":   mmdbInt(mmdbTypeUint16, uint64(recordSize)),
+		"ip_version":    mmdbInt(mmdbTypeUint16, 6),
+		"build_epoch":   mmdbInt(mmdbTypeUint64, 1700000000),
+		"database_type": mmdbStr("Test-City"),
+		"languages":     mmdbArray(mmdbStr("en"), mmdbStr("de")),
+		"description":   mmdbObj(map[string][]byte{"en": mmdbStr("test data")}),
+	})...)
+}
+
+func TestMMDBLookup(t *testing.T) {
+	// padding puts the shared names map past 2048 so a two-byte pointer
+	// reaches it
+	data := mmdbStr(string(make([]byte, 2100)))
+	names := len(data)
+	data = append(data, mmdbObj(map[string][]byte{"en": mmdbStr("Germany"), "de": mmdbStr("Deutschland")})...)
+	city := len(data)
+	data = append(data, mmdbObj(map[string][]byte{
+		"country":  mmdbObj(map[string][]byte{"iso_code": mmdbStr("DE"), "names": mmdbPtr(names)}),
+		"city":     mmdbObj(map[string][]byte{"names": mmdbObj(map[string][]byte{"en": mmdbStr("Berlin")})}),
+		"location": mmdbObj(map[string][]byte{"latitude": mmdbDouble(52.5), "longitude": mmdbDouble(13.4)}),
+		"big":      append(mmdbCtrl(mmdbTypeUint128, 16), 1, 0, 0, 0, 0, 0, 0, 0, 0, 0, 0, 0, 0, 0, 0, 0),
+	})...)
+	asn := len(data)
+	data = append(data, mmdbObj(map[string][]byte{
+		"autonomous_system_number":       mmdbInt(mmdbTypeUint32, 3320),
+		"autonomous_system_organization": mmdbStr("Deutsche Telekom AG"),
+	})...)
+
+	for _, size := range []int{24, 28, 32} {
+		db, err := NewMMDB(buildMMDB(map[string]int{"1.2.3.0/24": city, "2001:db8::/32": city}, data, size))
+		if err != nil {
+			t.Fatalf("record size %d: %v", size, err)
+		}
+		m := db.Metadata
+		if m.DatabaseType != "Test-City" || m.IPVersion != 6 || m.RecordSize != uint(size) ||
+			m.BuildEpoch != 1700000000 || len(m.Languages) != 2 || m.Description["en"] != "test data" {
+			t.Fatalf("record size %d: Metadata = %+v", size, m)
+		}
+
+		rec, err := db.Lookup(net.ParseIP("1.2.3.4"))
+		if err != nil || rec == nil {
+			t.Fatalf("record size %d: Lookup(1.2.3.4) = %v, %v", size, rec, err)
+		}
+		want := new(big.Int).Lsh(big.NewInt(1), 120)
+		if b, ok := rec["big"].(*big.Int); !ok || b.Cmp(want) != 0 {
+			t.Fatalf("uint128 = %v", rec["big"])
+		}
+		if rec, err := db.Lookup(net.ParseIP("9.9.9.9")); rec != nil || err != nil {
+			t.Fatalf("Lookup(9.9.9.9) = %v, %v", rec, err)
+		}
+
+		asnDB, err := NewMMDB(buildMMDB(map[string]int{"1.2.0.0/16": asn}, data, size))
+		if err != nil {
+			t.Fatal(err)
+		}
+		g, err := Enrich("1.2.3.4", db, asnDB)
+		if err != nil || g.CountryCode != "DE" || g.Country != "Germany" || g.City != "Berlin" ||
+			g.Latitude != 52.5 || g.ASN != 3320 || g.Org != "Deutsche Telekom AG" {
+			t.Fatalf("Enrich(1.2.3.4) = %+v, %v", g, err)
+		}
+		db.Language = "de"
+		if g, err := Enrich("2001:db8::1", db); err != nil || g.Country != "Deutschland" || g.City != "Berlin" {
+			t.Fatalf("Enrich(2001:db8::1) = %+v, %v", g, err)
+		}
+	}
+}
+
+func TestMMDBInvalid(t *testing.T) {
+	if _, err := NewMMDB([]byte("not a database")); err == nil {
+		t.Fatal("NewMMDB accepted a file without metadata")
+	}
+	db := buildMMDB(map[string]int{"1.2.3.0/24": 0}, mmdbStr("x"), 24)
+	if _, err := NewMMDB(db[:len(db)-3]); err == nil {
+		t.Fatal("NewMMDB accepted truncated metadata")
+	}
+}
@@ -0,0 +1,37 @@
+package tools
+
+import (
+	"errors"
+	"fmt"
+	"runtime/debug"
+)
+
+// ErrTooManyPanics is the Cause of a WorkPool run aborted by SetMaxPanics.
+var ErrTooManyPanics = errors.New("too many panics")
+
+// PanicError is a panic recovered from a task, with the stack of the
+// goroutine that panicked.
+type PanicError struct {
+	Value interface{}
+	Stack []byte
+}
+
+func (e *PanicError) Error() string {
+	return fmt.Sprintf("panic: %v", e.Value)
+}
+
+// Unwrap returns the panic value if it is an error, e.g. a runtime.Error.
+func (e *PanicError) Unwrap() error {
+	err, _ := e.Value.(error)
+	return err
+}
+
+// Safe calls fn and turns a panic in it into a *PanicError.
+func Safe(fn func() error) (err error) {
+	defer func() {
+		if v := recover(); v != nil {
+			err = &PanicError{Value: v, Stack: debug.Stack()}
+		}
+	}()
+	return fn()
+}
@@ -0,0 +1,142 @@
+package tools
+
+import (
+	"errors"
+	"math/bits"
+	"net"
+	"sort"
+)
+
+const feistelRounds = 4
+
+// PermutedIterator visits every address of a set exactly once in a
+// pseudo-random order fixed by its seed. The order comes from a Feistel
+// permutation of the address index, so nothing is materialized and two
+// iterators with the same seed agree on the order.
+type PermutedIterator struct {
+	ivs    []ipInterval
+	v4     int // ivs[:v4] are IPv4 intervals
+	starts []uint128
+	max    uint128 // index of the last address
+	half   uint
+	keys   [feistelRounds]uint64
+	next   uint128
+	done   bool
+}
+
+// NewPermutedIterator shuffles the addresses of s; later changes to s are not seen.
+func NewPermutedIterator(s *IPSet, seed int64) (*PermutedIterator, error) {
+	it := &PermutedIterator{v4: len(s.v4)}
+	it.ivs = append(append(it.ivs, s.v4...), s.v6...)
+	if len(it.ivs) == 0 {
+		it.done = true
+		return it, nil
+	}
+	var total uint128
+	for i, iv := range it.ivs {
+		it.starts = append(it.starts, total)
+		next := total.add(iv.last.sub(iv.first)).addOne()
+		if i > 0 && next.cmp(total) <= 0 {
+			return nil, errors.New("address set too large to permute")
+		}
+		total = next
+	}
+	it.max = total.subOne()
+	n := uint(128 - leadingZeros(it.max))
+	if n < 2 {
+		n = 2
+	}
+	it.half = (n + 1) / 2
+	k := uint64(seed)
+	for i := range it.keys {
+		k = splitmix64(k)
+		it.keys[i] = k
+	}
+	return it, nil
+}
+
+// NewRandomHostIterator shuffles the addresses of cidr, see NewHostIterator.
+func NewRandomHostIterator(cidr string, skipEdges bool, seed int64) (*PermutedIterator, error) {
+	_, ipnet, err := net.ParseCIDR(cidr)
+	if err != nil {
+		return nil, err
+	}
+	s := new(IPSet)
+	s.addRange(prefixBounds(ipnet, skipEdges))
+	return NewPermutedIterator(s, seed)
+}
+
+// NewRandomRangeIterator shuffles the addresses from start to end inclusive.
+func NewRandomRangeIterator(start, end string, seed int64) (*PermutedIterator, error) {
+	s := new(IPSet)
+	if err := s.AddRange(net.ParseIP(start), net.ParseIP(end)); err != nil {
+		return nil, err
+	}
+	return NewPermutedIterator(s, seed)
+}
+
+func (it *PermutedIterator) NextIP() (net.IP, bool) {
+	if it.done {
+		return nil, false
+	}
+	idx := it.permute(it.next)
+	// cycle-walk until the index falls back inside the set
+	for idx.cmp(it.max) > 0 {
+		idx = it.permute(idx)
+	}
+	if it.next == it.max {
+		it.done = true
+	} else {
+		it.next = it.next.addOne()
+	}
+	i := sort.Search(len(it.starts), func(i int) bool { return it.starts[i].cmp(idx) > 0 }) - 1
+	iv := it.ivs[i]
+	return uint128ToIP(iv.first.add(idx.sub(it.starts[i])), i < it.v4), true
+}
+
+func (it *PermutedIterator) Next() (string, bool) {
+	ip, ok := it.NextIP()
+	if !ok {
+		return "", false
+	}
+	return ip.String(), true
+}
+
+// permute is a balanced Feistel network over 2*half bits, a bijection
+// on [0, 2^(2*half)).
+func (it *PermutedIterator) permute(x uint128) uint128 {
+	mask := hostMask(int(it.half)).lo
+	l, r := shr128(x, it.half), x.lo&mask
+	for _, k := range it.keys {
+		l, r = r, l^(splitmix64(r^k)&mask)
+	}
+	return shl128(l, it.half).or(uint128{0, r})
+}
+
+func shr128(x uint128, n uint) uint64 {
+	if n >= 64 {
+		return x.hi >> (n - 64)
+	}
+	return x.hi<<(64-n) | x.lo>>n
+}
+
+func shl128(x uint64, n uint) uint128 {
+	if n >= 64 {
+		return uint128{x << (n - 64), 0}
+	}
+	return uint128{x >> (64 - n), x << n}
+}
+
+func leadingZeros(u uint128) int {
+	if u.hi != 0 {
+		return bits.LeadingZeros64(u.hi)
+	}
+	return 64 + bits.LeadingZeros64(u.lo)
+}
+
+func splitmix64(x uint64) uint64 {
+	x += 0x9e3779b97f4a7c15
+	x = (x ^ x>>30) * 0xbf58476d1ce4e5b9
+	x = (x ^ x>>27) * 0x94d049bb133111eb
+	return x ^ x>>31
+}
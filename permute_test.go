@@ -0,0 +1,63 @@
+package tools
+
+import (
+	"testing"
+)
+
+func TestPermutedIteratorVisitsEveryAddressOnce(t *testing.T) {
+	for _, spec := range []string{
+		"10.0.0.1",
+		"10.0.0.1-10.0.0.2",
+		"10.0.0.1-10.0.0.3",
+		"10.0.0.0/22",
+		"10.0.0.0/30 10.9.0.0-10.9.0.200 ::1-::5 2001:db8::/120",
+	} {
+		s, err := NewIPSet(spec)
+		if err != nil {
+			t.Fatal(err)
+		}
+		for _, seed := range []int64{0, 1, 42} {
+			it, err := NewPermutedIterator(s, seed)
+			if err != nil {
+				t.Fatal(err)
+			}
+			seen := make(map[string]bool)
+			for addr, ok := it.Next(); ok; addr, ok = it.Next() {
+				if seen[addr] || !s.Contains(addr) {
+					t.Fatalf("%s seed %d: %s repeated or outside the set", spec, seed, addr)
+				}
+				seen[addr] = true
+			}
+			if int64(len(seen)) != s.Len().Int64() {
+				t.Fatalf("%s seed %d: visited %d of %v addresses", spec, seed, len(seen), s.Len())
+			}
+		}
+	}
+}
+
+func TestPermutedIteratorSeed(t *testing.T) {
+	s, _ := NewIPSet("10.0.0.0/24")
+	order := func(seed int64) []string {
+		it, err := NewPermutedIterator(s, seed)
+		if err != nil {
+			t.Fatal(err)
+		}
+		return Collect(it)
+	}
+	a, b, c := order(7), order(7), order(8)
+	same, other := true, false
+	for i := range a {
+		same = same && a[i] == b[i]
+		other = other || a[i] != c[i]
+	}
+	if !same || !other {
+		t.Fatalf("same seed repeats: %v, other seed differs: %v", same, other)
+	}
+}
+
+func TestPermutedIteratorTooLarge(t *testing.T) {
+	s, _ := NewIPSet("::/0 1.1.1.1")
+	if _, err := NewPermutedIterator(s, 1); err == nil {
+		t.Fatal("NewPermutedIterator accepted more than 2^128 addresses")
+	}
+}
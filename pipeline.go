@@ -0,0 +1,221 @@
+package tools
+
+import (
+	"context"
+	"fmt"
+	"sync"
+	"sync/atomic"
+	"time"
+)
+
+// StageFunc handles one item of a pipeline stage and passes any number of
+// results to the next stage through emit, so a stage can drop, map or fan
+// out items. emit blocks while the next stage is full and fails once the
+// run is cancelled.
+type StageFunc func(ctx context.Context, item interface{}, emit func(interface{}) error) error
+
+// StageOptions tunes a stage. Buffer is the capacity of the channel that
+// feeds the stage; it defaults to 2*Workers.
+type StageOptions struct {
+	Workers int
+	Buffer  int
+}
+
+// StageError is the failure of a single item in a stage.
+type StageError struct {
+	Stage string
+	Item  interface{}
+	Err   error
+}
+
+func (e *StageError) Error() string {
+	return fmt.Sprintf("stage %s: %v: %v", e.Stage, e.Item, e.Err)
+}
+
+func (e *StageError) Unwrap() error { return e.Err }
+
+// StageStats counts the traffic of a stage. Busy is the summed time its
+// workers spent in the StageFunc, so Busy/Elapsed over Workers tells how
+// saturated the stage is.
+type StageStats struct {
+	Name    string
+	Workers int
+	Queued  int // items waiting in the stage's input channel
+	In      int64
+	Out     int64
+	Failed  int64
+	Busy    time.Duration
+}
+
+func (s StageStats) String() string {
+	return fmt.Sprintf("%s: workers %d queued %d in %d out %d failed %d busy %v",
+		s.Name, s.Workers, s.Queued, s.In, s.Out, s.Failed, s.Busy.Round(time.Millisecond))
+}
+
+type stage struct {
+	name string
+	fn   StageFunc
+	opt  StageOptions
+
+	in     chan interface{}
+	count  int64
+	out    int64
+	failed int64
+	busy   int64 // nanoseconds
+}
+
+//================================================================================
+
+// Pipeline chains stages, each with its own worker count, e.g. expand
+// targets, probe a port, fetch the HTTP title, write CSV. Stages are joined
+// by bounded channels, so a slow stage holds back the ones before it
+// instead of piling up items in memory.
+type Pipeline struct {
+	mu      sync.Mutex
+	stages  []*stage
+	onError []func(*StageError)
+}
+
+func NewPipeline() *Pipeline {
+	return &Pipeline{}
+}
+
+// Stage appends a stage. The items emitted by the last stage are discarded,
+// so it is where results get written out.
+func (pl *Pipeline) Stage(name string, fn StageFunc, opt StageOptions) *Pipeline {
+	if opt.Workers < 1 {
+		opt.Workers = 1
+	}
+	if opt.Buffer < 1 {
+		opt.Buffer = 2 * opt.Workers
+	}
+	pl.mu.Lock()
+	pl.stages = append(pl.stages, &stage{name: name, fn: fn, opt: opt})
+	pl.mu.Unlock()
+	return pl
+}
+
+// OnError registers fn to be called with every failed item. A failure only
+// drops that item; the run goes on. Hooks may be called concurrently.
+func (pl *Pipeline) OnError(fn func(*StageError)) {
+	pl.mu.Lock()
+	pl.onError = append(pl.onError, fn)
+	pl.mu.Unlock()
+}
+
+// Stats returns the counters of every stage; it may be called during Run.
+func (pl *Pipeline) Stats() []StageStats {
+	pl.mu.Lock()
+	defer pl.mu.Unlock()
+	stats := make([]StageStats, len(pl.stages))
+	for i, s := range pl.stages {
+		stats[i] = StageStats{
+			Name:    s.name,
+			Workers: s.opt.Workers,
+			In:      atomic.LoadInt64(&s.count),
+			Out:     atomic.LoadInt64(&s.out),
+			Failed:  atomic.LoadInt64(&s.failed),
+			Busy:    time.Duration(atomic.LoadInt64(&s.busy)),
+		}
+		if s.in != nil {
+			stats[i].Queued = len(s.in)
+		}
+	}
+	return stats
+}
+
+// Run feeds every item of src through the stages and returns once all of
+// them are through or ctx is done, in which case it returns ctx.Err().
+// Panics in a stage are recovered and reported as a *PanicError item error.
+func (pl *Pipeline) Run(ctx context.Context, src Iterator) error {
+	pl.mu.Lock()
+	stages := pl.stages
+	hooks := pl.onError
+	for _, s := range stages {
+		s.in = make(chan interface{}, s.opt.Buffer)
+		s.count, s.out, s.failed, s.busy = 0, 0, 0, 0
+	}
+	pl.mu.Unlock()
+	if len(stages) == 0 {
+		return nil
+	}
+
+	runCtx, cancel := context.WithCancel(ctx)
+	defer cancel()
+
+	go func() {
+		defer close(stages[0].in)
+		for item, ok := src.Next(); ok; item, ok = src.Next() {
+			select {
+			case stages[0].in <- item:
+			case <-runCtx.Done():
+				return
+			}
+		}
+	}()
+
+	var last sync.WaitGroup
+	for i, s := range stages {
+		var out chan interface{}
+		if i+1 < len(stages) {
+			out = stages[i+1].in
+		}
+		var wg sync.WaitGroup
+		wg.Add(s.opt.Workers)
+		for w := 0; w < s.opt.Workers; w++ {
+			go func(s *stage) {
+				defer wg.Done()
+				s.work(runCtx, out, hooks)
+			}(s)
+		}
+		if out != nil {
+			go func() {
+				wg.Wait()
+				close(out)
+			}()
+		} else {
+			last.Add(1)
+			go func() {
+				wg.Wait()
+				last.Done()
+			}()
+		}
+	}
+	last.Wait()
+	return ctx.Err()
+}
+
+// work consumes s.in until it is closed. After cancellation it keeps
+// draining without processing, so the stage before it can finish.
+func (s *stage) work(ctx context.Context, out chan interface{}, hooks []func(*StageError)) {
+	emit := func(v interface{}) error {
+		if out == nil {
+			atomic.AddInt64(&s.out, 1)
+			return nil
+		}
+		select {
+		case out <- v:
+			atomic.AddInt64(&s.out, 1)
+			return nil
+		case <-ctx.Done():
+			return ctx.Err()
+		}
+	}
+	for item := range s.in {
+		if ctx.Err() != nil {
+			continue
+		}
+		atomic.AddInt64(&s.count, 1)
+		start := time.Now()
+		err := Safe(func() error { return s.fn(ctx, item, emit) })
+		atomic.AddInt64(&s.busy, int64(time.Since(start)))
+		if err == nil || ctx.Err() != nil {
+			continue
+		}
+		atomic.AddInt64(&s.failed, 1)
+		se := &StageError{Stage: s.name, Item: item, Err: err}
+		for _, hook := range hooks {
+			hook(se)
+		}
+	}
+}
@@ -0,0 +1,123 @@
+package tools
+
+import (
+	"context"
+	"errors"
+	"strconv"
+	"sync"
+	"testing"
+	"time"
+)
+
+func numbers(n int) Iterator {
+	list := make([]string, n)
+	for i := range list {
+		list[i] = strconv.Itoa(i)
+	}
+	return SliceIterator(list)
+}
+
+func pass(ctx context.Context, item interface{}, emit func(interface{}) error) error {
+	return emit(item)
+}
+
+func TestPipelineBackpressure(t *testing.T) {
+	release := make(chan struct{})
+	pl := NewPipeline().
+		Stage("fast", pass, StageOptions{Workers: 1, Buffer: 1}).
+		Stage("slow", func(ctx context.Context, item interface{}, emit func(interface{}) error) error {
+			<-release
+			return nil
+		}, StageOptions{Workers: 1, Buffer: 1})
+
+	done := make(chan error, 1)
+	go func() { done <- pl.Run(context.Background(), numbers(100)) }()
+	time.Sleep(50 * time.Millisecond)
+	// slow holds one item, its buffer one more, fast one blocked in emit
+	// and its buffer one: nothing else may have been read from the source
+	if in := pl.Stats()[0].In; in > 3 {
+		t.Fatalf("fast stage took %d items while slow was stuck", in)
+	}
+	close(release)
+	if err := <-done; err != nil {
+		t.Fatal(err)
+	}
+	if st := pl.Stats(); st[0].Out != 100 || st[1].In != 100 {
+		t.Fatalf("stats after run: %v", st)
+	}
+}
+
+func TestPipelineCancel(t *testing.T) {
+	ctx, cancel := context.WithCancel(context.Background())
+	pl := NewPipeline().
+		Stage("expand", func(ctx context.Context, item interface{}, emit func(interface{}) error) error {
+			for i := 0; i < 10; i++ {
+				if err := emit(item); err != nil {
+					return err
+				}
+			}
+			return nil
+		}, StageOptions{Workers: 2}).
+		Stage("stuck", func(ctx context.Context, item interface{}, emit func(interface{}) error) error {
+			<-ctx.Done()
+			return ctx.Err()
+		}, StageOptions{Workers: 2})
+	pl.OnError(func(se *StageError) {
+		t.Errorf("failure reported after cancel: %v", se)
+	})
+
+	done := make(chan error, 1)
+	go func() { done <- pl.Run(ctx, numbers(1000)) }()
+	time.Sleep(20 * time.Millisecond)
+	cancel()
+	select {
+	case err := <-done:
+		if err != context.Canceled {
+			t.Fatalf("Run() = %v, want context.Canceled", err)
+		}
+	case <-time.After(time.Second):
+		t.Fatal("Run did not return after cancel")
+	}
+	if in := pl.Stats()[0].In; in >= 1000 {
+		t.Fatalf("expand processed %d items, cancel did not stop it", in)
+	}
+}
+
+func TestPipelinePanic(t *testing.T) {
+	var mu sync.Mutex
+	var failed []*StageError
+	var written []string
+	pl := NewPipeline().
+		Stage("parse", func(ctx context.Context, item interface{}, emit func(interface{}) error) error {
+			if item == "3" {
+				panic("bad item")
+			}
+			return emit(item)
+		}, StageOptions{Workers: 4}).
+		Stage("write", func(ctx context.Context, item interface{}, emit func(interface{}) error) error {
+			mu.Lock()
+			written = append(written, item.(string))
+			mu.Unlock()
+			return nil
+		}, StageOptions{})
+	pl.OnError(func(se *StageError) {
+		mu.Lock()
+		failed = append(failed, se)
+		mu.Unlock()
+	})
+
+	if err := pl.Run(context.Background(), numbers(10)); err != nil {
+		t.Fatal(err)
+	}
+	if len(failed) != 1 || len(written) != 9 {
+		t.Fatalf("%d failed, %d written", len(failed), len(written))
+	}
+	se := failed[0]
+	var pe *PanicError
+	if se.Stage != "parse" || se.Item != "3" || !errors.As(se, &pe) || pe.Value != "bad item" {
+		t.Fatalf("StageError = %#v", se)
+	}
+	if st := pl.Stats()[0]; st.Failed != 1 || st.Out != 9 {
+		t.Fatalf("parse stats: %v", st)
+	}
+}
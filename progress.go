@@ -0,0 +1,189 @@
+package tools
+
+import (
+	"context"
+	"fmt"
+	"io"
+	"os"
+	"strings"
+	"sync"
+	"sync/atomic"
+	"time"
+)
+
+const progressWindow = 10 // seconds of history behind Rate
+
+// Progress tracks a long run: how many tasks are done, failed and in flight,
+// the throughput over the last few seconds and the time left.
+type Progress struct {
+	total  int64
+	done   int64
+	failed int64
+	start  time.Time
+
+	mu      sync.Mutex
+	pool    *WorkPool
+	buckets [progressWindow]int64
+	stamps  [progressWindow]int64
+}
+
+// ProgressStats is a snapshot of a Progress.
+type ProgressStats struct {
+	Total    int64 // 0 when unknown
+	Done     int64
+	Failed   int64
+	Skipped  int64 // completed in an earlier run, see UseCheckpoint
+	InFlight int64
+	Rate     float64 // tasks per second over the sliding window
+	Elapsed  time.Duration
+	ETA      time.Duration // 0 when unknown
+}
+
+// NewProgress tracks a run of total tasks; pass 0 if the total is unknown.
+func NewProgress(total int64) *Progress {
+	return &Progress{total: total, start: time.Now()}
+}
+
+// SetTotal updates the expected number of tasks.
+func (pr *Progress) SetTotal(n int64) { atomic.StoreInt64(&pr.total, n) }
+
+// Attach feeds pr from the results of p.
+func (pr *Progress) Attach(p *WorkPool) {
+	pr.mu.Lock()
+	pr.pool = p
+	pr.mu.Unlock()
+	p.OnResult(func(res TaskResult) { pr.Add(res.Err) })
+}
+
+// Add records one finished task; use it when not running on a WorkPool.
+func (pr *Progress) Add(err error) {
+	if err != nil {
+		atomic.AddInt64(&pr.failed, 1)
+	} else {
+		atomic.AddInt64(&pr.done, 1)
+	}
+	sec := time.Now().Unix()
+	i := sec % progressWindow
+	pr.mu.Lock()
+	if pr.stamps[i] != sec {
+		pr.stamps[i], pr.buckets[i] = sec, 0
+	}
+	pr.buckets[i]++
+	pr.mu.Unlock()
+}
+
+func (pr *Progress) Snapshot() ProgressStats {
+	now := time.Now()
+	s := ProgressStats{
+		Total:   atomic.LoadInt64(&pr.total),
+		Done:    atomic.LoadInt64(&pr.done),
+		Failed:  atomic.LoadInt64(&pr.failed),
+		Elapsed: now.Sub(pr.start),
+	}
+	pr.mu.Lock()
+	pool := pr.pool
+	var n int64
+	for i, stamp := range pr.stamps {
+		if now.Unix()-stamp < progressWindow {
+			n += pr.buckets[i]
+		}
+	}
+	pr.mu.Unlock()
+	if pool != nil {
+		s.InFlight = int64(pool.Running())
+		s.Skipped = int64(pool.Skipped())
+	}
+
+	// the window holds the full past seconds plus the current partial one
+	span := s.Elapsed.Seconds()
+	if max := progressWindow - 1 + float64(now.Nanosecond())/1e9; span > max {
+		span = max
+	}
+	if span < 0.1 {
+		span = 0.1
+	}
+	s.Rate = float64(n) / span
+	if left := s.Total - s.Done - s.Failed - s.Skipped; s.Total > 0 && left > 0 && s.Rate > 0 {
+		s.ETA = time.Duration(float64(left) / s.Rate * float64(time.Second))
+	}
+	return s
+}
+
+func (s ProgressStats) String() string {
+	finished := s.Done + s.Failed + s.Skipped
+	var b strings.Builder
+	if s.Total > 0 {
+		fmt.Fprintf(&b, "%d/%d (%.1f%%)", finished, s.Total, 100*float64(finished)/float64(s.Total))
+	} else {
+		fmt.Fprintf(&b, "%d", finished)
+	}
+	fmt.Fprintf(&b, " failed %d running %d | %.1f/s | elapsed %v", s.Failed, s.InFlight, s.Rate, s.Elapsed.Round(time.Second))
+	if s.ETA > 0 {
+		fmt.Fprintf(&b, " ETA %v", s.ETA.Round(time.Second))
+	}
+	return b.String()
+}
+
+//================================================================================
+
+// Report prints pr to w every interval until ctx is done, then prints a
+// final line. On a terminal it redraws a progress bar in place; otherwise
+// (a pipe or log file) it writes one timestamped line per interval.
+func (pr *Progress) Report(ctx context.Context, w io.Writer, interval time.Duration) {
+	tty := isTerminal(w)
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+	last := 0
+	for {
+		select {
+		case <-ticker.C:
+			last = pr.render(w, tty, last)
+		case <-ctx.Done():
+			pr.render(w, tty, last)
+			if tty {
+				fmt.Fprintln(w)
+			}
+			return
+		}
+	}
+}
+
+// render writes one report and returns the length of the line it drew, so
+// the next redraw can blank out what is left of it.
+func (pr *Progress) render(w io.Writer, tty bool, last int) int {
+	s := pr.Snapshot()
+	if !tty {
+		fmt.Fprintln(w, time.Now().Format("2006-01-02 15:04:05"), s)
+		return 0
+	}
+	const width = 30
+	bar := strings.Repeat(" ", width)
+	if s.Total > 0 {
+		n := int(width * (s.Done + s.Failed + s.Skipped) / s.Total)
+		if n > width {
+			n = width
+		}
+		bar = strings.Repeat("=", n) + strings.Repeat(" ", width-n)
+		if n < width && n > 0 {
+			bar = bar[:n-1] + ">" + bar[n:]
+		}
+	}
+	// pad with spaces rather than \033[K, which the Windows console prints
+	// as garbage unless VT processing is enabled
+	line := fmt.Sprintf("[%s] %s", bar, s)
+	n := len(line)
+	if n < last {
+		line += strings.Repeat(" ", last-n)
+	}
+	fmt.Fprint(w, "\r"+line)
+	return n
+}
+
+func isTerminal(w io.Writer) bool {
+	f, ok := w.(*os.File)
+	if !ok {
+		return false
+	}
+	fi, err := f.Stat()
+	return err == nil && fi.Mode()&os.ModeCharDevice != 0
+}
@@ -0,0 +1,24 @@
+package tools
+
+import (
+	"bytes"
+	"strings"
+	"testing"
+)
+
+func TestProgressRenderPadsShorterLine(t *testing.T) {
+	pr := NewProgress(10)
+	pr.Add(nil)
+	var buf bytes.Buffer
+	n := pr.render(&buf, true, 200)
+	out := buf.String()
+	if strings.Contains(out, "\033") {
+		t.Fatalf("render wrote an escape sequence: %q", out)
+	}
+	if !strings.HasPrefix(out, "\r[") || len(out) != 1+200 || n >= 200 {
+		t.Fatalf("render(last=200) = %d, %q", n, out)
+	}
+	if strings.TrimRight(out, " ") != out[:1+n] {
+		t.Fatalf("line of %d not padded with spaces: %q", n, out)
+	}
+}
@@ -0,0 +1,156 @@
+package tools
+
+import (
+	"context"
+	"sync"
+	"time"
+)
+
+// Limiter makes a task wait for permission to run; key is the task's Key,
+// e.g. the target host or the API token it uses.
+type Limiter interface {
+	Wait(ctx context.Context, key string) error
+}
+
+// TokenBucket allows rate events per second with bursts of up to burst;
+// a rate of zero or less means no limit. It ignores keys, so it caps a
+// whole pool.
+type TokenBucket struct {
+	mu     sync.Mutex
+	rate   float64
+	burst  float64
+	tokens float64
+	last   time.Time
+}
+
+// NewTokenBucket returns a full bucket.
+func NewTokenBucket(rate float64, burst int) *TokenBucket {
+	if burst < 1 {
+		burst = 1
+	}
+	return &TokenBucket{rate: rate, burst: float64(burst), tokens: float64(burst), last: time.Now()}
+}
+
+func (b *TokenBucket) refillLocked(now time.Time) {
+	b.tokens += now.Sub(b.last).Seconds() * b.rate
+	if b.tokens > b.burst {
+		b.tokens = b.burst
+	}
+	b.last = now
+}
+
+// Allow takes a token if one is available right now.
+func (b *TokenBucket) Allow() bool {
+	if b.rate <= 0 {
+		return true
+	}
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	b.refillLocked(time.Now())
+	if b.tokens < 1 {
+		return false
+	}
+	b.tokens--
+	return true
+}
+
+// Wait blocks until a token is available or ctx is done.
+func (b *TokenBucket) Wait(ctx context.Context, key string) error {
+	if b.rate <= 0 {
+		return nil
+	}
+	b.mu.Lock()
+	b.refillLocked(time.Now())
+	b.tokens--
+	var delay time.Duration
+	if b.tokens < 0 {
+		delay = time.Duration(-b.tokens / b.rate * float64(time.Second))
+	}
+	b.mu.Unlock()
+	if delay == 0 {
+		return nil
+	}
+
+	timer := time.NewTimer(delay)
+	defer timer.Stop()
+	select {
+	case <-timer.C:
+		return nil
+	case <-ctx.Done():
+		// hand the reserved token back
+		b.mu.Lock()
+		b.tokens++
+		b.mu.Unlock()
+		return ctx.Err()
+	}
+}
+
+// full reports whether the bucket has refilled completely, i.e. is idle.
+func (b *TokenBucket) full() bool {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	b.refillLocked(time.Now())
+	return b.tokens >= b.burst
+}
+
+//================================================================================
+
+// KeyedLimiter keeps a separate TokenBucket per key, so every host or API
+// token gets its own rate. Idle buckets are dropped as new keys come in.
+type KeyedLimiter struct {
+	rate  float64
+	burst int
+
+	mu      sync.Mutex
+	buckets map[string]*TokenBucket
+	sweepAt int
+}
+
+func NewKeyedLimiter(rate float64, burst int) *KeyedLimiter {
+	return &KeyedLimiter{rate: rate, burst: burst, buckets: make(map[string]*TokenBucket), sweepAt: 1024}
+}
+
+func (l *KeyedLimiter) bucket(key string) *TokenBucket {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	if b, ok := l.buckets[key]; ok {
+		return b
+	}
+	if len(l.buckets) >= l.sweepAt {
+		for k, b := range l.buckets {
+			if b.full() {
+				delete(l.buckets, k)
+			}
+		}
+		l.sweepAt = 2 * len(l.buckets)
+		if l.sweepAt < 1024 {
+			l.sweepAt = 1024
+		}
+	}
+	b := NewTokenBucket(l.rate, l.burst)
+	l.buckets[key] = b
+	return b
+}
+
+// Allow takes a token for key if one is available right now.
+func (l *KeyedLimiter) Allow(key string) bool {
+	return l.bucket(key).Allow()
+}
+
+// Wait blocks until key has a token or ctx is done.
+func (l *KeyedLimiter) Wait(ctx context.Context, key string) error {
+	return l.bucket(key).Wait(ctx, key)
+}
+
+// Limiters chains limiters, e.g. a global TokenBucket and a KeyedLimiter;
+// a task runs once it got past all of them.
+type Limiters []Limiter
+
+func (ls Limiters) Wait(ctx context.Context, key string) error {
+	for _, l := range ls {
+		if err := l.Wait(ctx, key); err != nil {
+			return err
+		}
+	}
+	return nil
+}
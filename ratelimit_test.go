@@ -0,0 +1,65 @@
+package tools
+
+import (
+	"context"
+	"testing"
+	"time"
+)
+
+func TestKeyedLimiterTiming(t *testing.T) {
+	l := NewKeyedLimiter(20, 2) // one token every 50ms per key
+	ctx := context.Background()
+	start := time.Now()
+	for i := 0; i < 4; i++ {
+		if err := l.Wait(ctx, "a"); err != nil {
+			t.Fatal(err)
+		}
+	}
+	// the burst of 2 is free, the next two wait 50ms each
+	if d := time.Since(start); d < 90*time.Millisecond || d > 300*time.Millisecond {
+		t.Fatalf("4 waits on one key took %v, want about 100ms", d)
+	}
+
+	// another key has its own full bucket
+	start = time.Now()
+	if !l.Allow("b") || !l.Allow("b") || l.Allow("b") {
+		t.Fatal("key b does not have a burst of 2")
+	}
+	if err := l.Wait(ctx, "c"); err != nil || time.Since(start) > 20*time.Millisecond {
+		t.Fatalf("key c waited %v on the other keys", time.Since(start))
+	}
+}
+
+func TestTokenBucketWaitCancel(t *testing.T) {
+	b := NewTokenBucket(1, 1)
+	b.Allow()
+	ctx, cancel := context.WithTimeout(context.Background(), 20*time.Millisecond)
+	defer cancel()
+	if err := b.Wait(ctx, ""); err != context.DeadlineExceeded {
+		t.Fatalf("Wait() = %v", err)
+	}
+	// the cancelled wait gave its reservation back
+	b.mu.Lock()
+	tokens := b.tokens
+	b.mu.Unlock()
+	if tokens < -0.1 {
+		t.Fatalf("tokens = %v after a cancelled wait", tokens)
+	}
+}
+
+func TestWorkPoolLimiter(t *testing.T) {
+	p := NewWorkPool(context.Background(), 10)
+	p.UseLimiter(NewKeyedLimiter(50, 1))
+	start := time.Now()
+	for i := 0; i < 3; i++ {
+		p.SubmitTask(&Task{Key: "host", Run: func(ctx context.Context) error { return nil }})
+		p.SubmitTask(&Task{Key: "other", Run: func(ctx context.Context) error { return nil }})
+	}
+	if err := p.Wait(); err != nil {
+		t.Fatal(err)
+	}
+	// three tasks per key at 50/s: the last waits 40ms whatever the workers
+	if d := time.Since(start); d < 35*time.Millisecond {
+		t.Fatalf("run took %v, limiter not applied per key", d)
+	}
+}
@@ -0,0 +1,468 @@
+package tools
+
+import (
+	"context"
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"io"
+	"math/rand"
+	"net"
+	"strings"
+	"sync"
+	"time"
+
+	"golang.org/x/net/dns/dnsmessage"
+)
+
+// RecordType is a DNS query type.
+type RecordType uint16
+
+const (
+	TypeA     = RecordType(dnsmessage.TypeA)
+	TypeAAAA  = RecordType(dnsmessage.TypeAAAA)
+	TypeCNAME = RecordType(dnsmessage.TypeCNAME)
+	TypeMX    = RecordType(dnsmessage.TypeMX)
+	TypePTR   = RecordType(dnsmessage.TypePTR)
+	TypeTXT   = RecordType(dnsmessage.TypeTXT)
+)
+
+func (t RecordType) String() string {
+	return strings.TrimPrefix(dnsmessage.Type(t).String(), "Type")
+}
+
+var (
+	ErrNXDomain     = errors.New("no such domain")
+	ErrDNSTimeout   = errors.New("dns query timed out")
+	ErrNoNameserver = errors.New("no system nameserver configured")
+)
+
+// DNSError is returned for a failed lookup; test it against ErrNXDomain or
+// ErrDNSTimeout with errors.Is.
+type DNSError struct {
+	Name   string
+	Type   RecordType
+	Server string
+	Err    error
+}
+
+func (e *DNSError) Error() string {
+	return fmt.Sprintf("lookup %s %s on %s: %v", e.Type, e.Name, e.Server, e.Err)
+}
+
+func (e *DNSError) Unwrap() error { return e.Err }
+
+// DNSRecord is a single answer. Value is the address, target name or text;
+// Pref is only set for MX records.
+type DNSRecord struct {
+	Name  string
+	Type  RecordType
+	TTL   uint32
+	Value string
+	Pref  uint16
+}
+
+// DNSResult is the outcome of one lookup made by LookupAll.
+type DNSResult struct {
+	Name    string
+	Type    RecordType
+	Records []DNSRecord
+	Err     error
+}
+
+//================================================================================
+
+// Resolver sends queries straight to one DNS server and caches the answers
+// for their TTL. Point Server at a local stand-in to test without network.
+type Resolver struct {
+	Server   string // host:port
+	Timeout  time.Duration
+	Retries  int
+	Workers  int // concurrency of LookupAll
+	MaxCache int // cached answers kept at most, 0 for no limit
+
+	mu    sync.Mutex
+	cache map[dnsCacheKey]dnsCacheEntry
+	swept int // cache size after the last sweep
+}
+
+type dnsCacheKey struct {
+	name  string
+	qtype RecordType
+}
+
+type dnsCacheEntry struct {
+	records []DNSRecord
+	err     error
+	expires time.Time
+}
+
+// NewResolver queries server ("8.8.8.8" or "127.0.0.1:5353"); an empty
+// server means the first DNS server of the system's network adapters, and
+// ErrNoNameserver if there is none.
+func NewResolver(server string) (*Resolver, error) {
+	if server == "" {
+		var err error
+		if server, err = systemNameserver(); err != nil {
+			return nil, err
+		}
+	}
+	if _, _, err := net.SplitHostPort(server); err != nil {
+		server = net.JoinHostPort(server, "53")
+	}
+	return &Resolver{
+		Server:   server,
+		Timeout:  3 * time.Second,
+		Retries:  2,
+		Workers:  20,
+		MaxCache: 10000,
+		cache:    make(map[dnsCacheKey]dnsCacheEntry),
+	}, nil
+}
+
+// Lookup returns the records of type qtype for name.
+func (r *Resolver) Lookup(ctx context.Context, name string, qtype RecordType) ([]DNSRecord, error) {
+	if !strings.HasSuffix(name, ".") {
+		name += "."
+	}
+	key := dnsCacheKey{strings.ToLower(name), qtype}
+	r.mu.Lock()
+	if e, ok := r.cache[key]; ok {
+		if time.Now().Before(e.expires) {
+			r.mu.Unlock()
+			return e.records, e.err
+		}
+		delete(r.cache, key)
+	}
+	r.mu.Unlock()
+
+	records, ttl, err := r.query(ctx, name, qtype)
+	if err != nil {
+		err = &DNSError{Name: name, Type: qtype, Server: r.Server, Err: err}
+	}
+	if ttl > 0 {
+		r.mu.Lock()
+		r.cacheLocked(key, dnsCacheEntry{records, err, time.Now().Add(time.Duration(ttl) * time.Second)})
+		r.mu.Unlock()
+	}
+	return records, err
+}
+
+// cacheLocked stores e. Expired entries are swept whenever the cache has
+// doubled since the last sweep, and past MaxCache arbitrary entries go.
+func (r *Resolver) cacheLocked(key dnsCacheKey, e dnsCacheEntry) {
+	if len(r.cache) >= 2*r.swept+64 || r.MaxCache > 0 && len(r.cache) >= r.MaxCache {
+		now := time.Now()
+		for k, old := range r.cache {
+			if !now.Before(old.expires) {
+				delete(r.cache, k)
+			}
+		}
+		for k := range r.cache {
+			if r.MaxCache <= 0 || len(r.cache) < r.MaxCache {
+				break
+			}
+			delete(r.cache, k)
+		}
+		r.swept = len(r.cache)
+	}
+	r.cache[key] = e
+}
+
+// LookupIP returns both the IPv4 and IPv6 addresses of host.
+func (r *Resolver) LookupIP(ctx context.Context, host string) ([]net.IP, error) {
+	var ips []net.IP
+	var firstErr error
+	for _, qtype := range []RecordType{TypeA, TypeAAAA} {
+		records, err := r.Lookup(ctx, host, qtype)
+		if err != nil && firstErr == nil {
+			firstErr = err
+		}
+		for _, rec := range records {
+			ips = append(ips, net.ParseIP(rec.Value))
+		}
+	}
+	if len(ips) > 0 {
+		return ips, nil
+	}
+	return nil, firstErr
+}
+
+// LookupAddr returns the PTR names of an address.
+func (r *Resolver) LookupAddr(ctx context.Context, addr string) ([]string, error) {
+	name, err := reverseName(addr)
+	if err != nil {
+		return nil, err
+	}
+	records, err := r.Lookup(ctx, name, TypePTR)
+	names := make([]string, len(records))
+	for i, rec := range records {
+		names[i] = rec.Value
+	}
+	return names, err
+}
+
+// LookupAll resolves every name of it concurrently, at most Workers at a
+// time, and hands each result to fn. For PTR lookups the names may be
+// plain addresses. fn is never called concurrently; a panic in fn is
+// logged and does not stop the other lookups.
+func (r *Resolver) LookupAll(ctx context.Context, it Iterator, qtype RecordType, fn func(DNSResult)) {
+	p := NewPool(r.Workers, 0)
+	var mu sync.Mutex
+	for name, ok := it.Next(); ok && ctx.Err() == nil; name, ok = it.Next() {
+		name := name
+		p.Wg.Add(1)
+		p.Go(func() {
+			res := DNSResult{Name: name, Type: qtype}
+			if qtype == TypePTR && net.ParseIP(name) != nil {
+				var names []string
+				names, res.Err = r.LookupAddr(ctx, name)
+				for _, n := range names {
+					res.Records = append(res.Records, DNSRecord{Name: name, Type: TypePTR, Value: n})
+				}
+			} else {
+				res.Records, res.Err = r.Lookup(ctx, name, qtype)
+			}
+			// a panicking fn is recovered by p.Go and must not keep mu locked
+			mu.Lock()
+			defer mu.Unlock()
+			fn(res)
+		})
+	}
+	p.Wg.Wait()
+}
+
+//================================================================================
+
+// query asks the server and returns the answers with the TTL they may be
+// cached for; timeouts are never cached.
+func (r *Resolver) query(ctx context.Context, name string, qtype RecordType) ([]DNSRecord, uint32, error) {
+	qname, err := dnsmessage.NewName(name)
+	if err != nil {
+		return nil, 0, err
+	}
+	id := uint16(rand.Uint32())
+	msg := dnsmessage.Message{
+		Header: dnsmessage.Header{ID: id, RecursionDesired: true},
+		Questions: []dnsmessage.Question{
+			{Name: qname, Type: dnsmessage.Type(qtype), Class: dnsmessage.ClassINET},
+		},
+	}
+	req, err := msg.Pack()
+	if err != nil {
+		return nil, 0, err
+	}
+	var resp []byte
+	for attempt := 0; attempt <= r.Retries; attempt++ {
+		resp, err = r.exchange(ctx, "udp", req, id)
+		if err == nil || ctx.Err() != nil {
+			break
+		}
+	}
+	if err == nil {
+		var h dnsmessage.Header
+		var p dnsmessage.Parser
+		if h, err = p.Start(resp); err == nil && h.Truncated {
+			resp, err = r.exchange(ctx, "tcp", req, id)
+		}
+	}
+	if err != nil {
+		if ctx.Err() == context.Canceled {
+			return nil, 0, ctx.Err()
+		}
+		if ne, ok := err.(net.Error); ok && ne.Timeout() || ctx.Err() == context.DeadlineExceeded {
+			err = ErrDNSTimeout
+		}
+		return nil, 0, err
+	}
+	return parseDNSResponse(resp, qtype)
+}
+
+func (r *Resolver) exchange(ctx context.Context, network string, req []byte, id uint16) ([]byte, error) {
+	d := net.Dialer{Timeout: r.Timeout}
+	conn, err := d.DialContext(ctx, network, r.Server)
+	if err != nil {
+		return nil, err
+	}
+	defer conn.Close()
+	deadline := time.Now().Add(r.Timeout)
+	if dl, ok := ctx.Deadline(); ok && dl.Before(deadline) {
+		deadline = dl
+	}
+	conn.SetDeadline(deadline)
+	stop := make(chan struct{})
+	defer close(stop)
+	go func() {
+		select {
+		case <-ctx.Done():
+			conn.SetDeadline(time.Now())
+		case <-stop:
+		}
+	}()
+
+	if network == "tcp" {
+		buf := make([]byte, 2+len(req))
+		binary.BigEndian.PutUint16(buf, uint16(len(req)))
+		copy(buf[2:], req)
+		if _, err := conn.Write(buf); err != nil {
+			return nil, err
+		}
+		if _, err := io.ReadFull(conn, buf[:2]); err != nil {
+			return nil, err
+		}
+		resp := make([]byte, binary.BigEndian.Uint16(buf[:2]))
+		_, err := io.ReadFull(conn, resp)
+		return resp, err
+	}
+	if _, err := conn.Write(req); err != nil {
+		return nil, err
+	}
+	buf := make([]byte, 4096)
+	for {
+		n, err := conn.Read(buf)
+		if err != nil {
+			return nil, err
+		}
+		// drop stray datagrams that do not answer our query
+		if n >= 2 && binary.BigEndian.Uint16(buf) == id {
+			return buf[:n], nil
+		}
+	}
+}
+
+func parseDNSResponse(resp []byte, qtype RecordType) ([]DNSRecord, uint32, error) {
+	var p dnsmessage.Parser
+	h, err := p.Start(resp)
+	if err != nil {
+		return nil, 0, err
+	}
+	if err := p.SkipAllQuestions(); err != nil {
+		return nil, 0, err
+	}
+	switch h.RCode {
+	case dnsmessage.RCodeSuccess:
+	case dnsmessage.RCodeNameError:
+		return nil, negativeTTL(&p), ErrNXDomain
+	default:
+		return nil, 0, errors.New(strings.TrimPrefix(h.RCode.String(), "RCode"))
+	}
+
+	var records []DNSRecord
+	var ttl uint32
+	for {
+		rh, err := p.AnswerHeader()
+		if err == dnsmessage.ErrSectionDone {
+			break
+		}
+		if err != nil {
+			return nil, 0, err
+		}
+		if ttl == 0 || rh.TTL < ttl {
+			ttl = rh.TTL
+		}
+		if RecordType(rh.Type) != qtype {
+			if err := p.SkipAnswer(); err != nil {
+				return nil, 0, err
+			}
+			continue
+		}
+		rec := DNSRecord{Name: rh.Name.String(), Type: qtype, TTL: rh.TTL}
+		switch qtype {
+		case TypeA:
+			body, err := p.AResource()
+			if err != nil {
+				return nil, 0, err
+			}
+			rec.Value = net.IP(body.A[:]).String()
+		case TypeAAAA:
+			body, err := p.AAAAResource()
+			if err != nil {
+				return nil, 0, err
+			}
+			rec.Value = net.IP(body.AAAA[:]).String()
+		case TypeCNAME:
+			body, err := p.CNAMEResource()
+			if err != nil {
+				return nil, 0, err
+			}
+			rec.Value = body.CNAME.String()
+		case TypePTR:
+			body, err := p.PTRResource()
+			if err != nil {
+				return nil, 0, err
+			}
+			rec.Value = body.PTR.String()
+		case TypeMX:
+			body, err := p.MXResource()
+			if err != nil {
+				return nil, 0, err
+			}
+			rec.Value, rec.Pref = body.MX.String(), body.Pref
+		case TypeTXT:
+			body, err := p.TXTResource()
+			if err != nil {
+				return nil, 0, err
+			}
+			rec.Value = strings.Join(body.TXT, "")
+		default:
+			if err := p.SkipAnswer(); err != nil {
+				return nil, 0, err
+			}
+			continue
+		}
+		records = append(records, rec)
+	}
+	if len(records) == 0 {
+		// NODATA is cached like a negative answer
+		return nil, negativeTTL(&p), nil
+	}
+	return records, ttl, nil
+}
+
+// negativeTTL reads the SOA minimum from the authority section (RFC 2308).
+func negativeTTL(p *dnsmessage.Parser) uint32 {
+	if err := p.SkipAllAnswers(); err != nil {
+		return 0
+	}
+	for {
+		h, err := p.AuthorityHeader()
+		if err != nil {
+			return 0
+		}
+		if h.Type != dnsmessage.TypeSOA {
+			if p.SkipAuthority() != nil {
+				return 0
+			}
+			continue
+		}
+		soa, err := p.SOAResource()
+		if err != nil {
+			return 0
+		}
+		if soa.MinTTL < h.TTL {
+			return soa.MinTTL
+		}
+		return h.TTL
+	}
+}
+
+// reverseName returns the in-addr.arpa or ip6.arpa name of addr.
+func reverseName(addr string) (string, error) {
+	ip := net.ParseIP(addr)
+	if ip == nil {
+		return "", fmt.Errorf("%w: %q", ErrBadAddress, addr)
+	}
+	if v4 := ip.To4(); v4 != nil {
+		return fmt.Sprintf("%d.%d.%d.%d.in-addr.arpa.", v4[3], v4[2], v4[1], v4[0]), nil
+	}
+	const hexDigits = "0123456789abcdef"
+	var b strings.Builder
+	for i := len(ip) - 1; i >= 0; i-- {
+		b.WriteByte(hexDigits[ip[i]&0xf])
+		b.WriteByte('.')
+		b.WriteByte(hexDigits[ip[i]>>4])
+		b.WriteByte('.')
+	}
+	b.WriteString("ip6.arpa.")
+	return b.String(), nil
+}
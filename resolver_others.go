@@ -0,0 +1,33 @@
+//go:build !windows
+// +build !windows
+
+package tools
+
+import (
+	"bufio"
+	"os"
+	"strings"
+)
+
+// systemNameserver returns the first nameserver of /etc/resolv.conf.
+func systemNameserver() (string, error) {
+	f, err := os.Open("/etc/resolv.conf")
+	if err != nil {
+		if os.IsNotExist(err) {
+			return "", ErrNoNameserver
+		}
+		return "", err
+	}
+	defer f.Close()
+	scanner := bufio.NewScanner(f)
+	for scanner.Scan() {
+		fields := strings.Fields(scanner.Text())
+		if len(fields) >= 2 && fields[0] == "nameserver" {
+			return fields[1], nil
+		}
+	}
+	if err := scanner.Err(); err != nil {
+		return "", err
+	}
+	return "", ErrNoNameserver
+}
@@ -0,0 +1,173 @@
+package tools
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"net"
+	"sync"
+	"testing"
+	"time"
+
+	"golang.org/x/net/dns/dnsmessage"
+)
+
+// fakeDNS answers A queries for example.test. on a loopback UDP socket,
+// NXDOMAIN for other names and never answers slow.test.
+type fakeDNS struct {
+	pc      net.PacketConn
+	mu      sync.Mutex
+	queries int
+}
+
+func newFakeDNS(t *testing.T) *fakeDNS {
+	pc, err := net.ListenPacket("udp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	s := &fakeDNS{pc: pc}
+	go s.serve()
+	return s
+}
+
+func (s *fakeDNS) serve() {
+	buf := make([]byte, 512)
+	for {
+		n, addr, err := s.pc.ReadFrom(buf)
+		if err != nil {
+			return
+		}
+		s.mu.Lock()
+		s.queries++
+		s.mu.Unlock()
+		var m dnsmessage.Message
+		if err := m.Unpack(buf[:n]); err != nil || len(m.Questions) != 1 {
+			continue
+		}
+		q := m.Questions[0]
+		switch q.Name.String() {
+		case "slow.test.":
+			continue
+		case "example.test.":
+			m.Answers = []dnsmessage.Resource{{
+				Header: dnsmessage.ResourceHeader{Name: q.Name, Type: dnsmessage.TypeA, Class: dnsmessage.ClassINET, TTL: 60},
+				Body:   &dnsmessage.AResource{A: [4]byte{192, 0, 2, 1}},
+			}}
+		default:
+			m.RCode = dnsmessage.RCodeNameError
+		}
+		m.Response = true
+		out, err := m.Pack()
+		if err != nil {
+			continue
+		}
+		s.pc.WriteTo(out, addr)
+	}
+}
+
+func (s *fakeDNS) Queries() int {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	return s.queries
+}
+
+func TestResolverLookup(t *testing.T) {
+	s := newFakeDNS(t)
+	defer s.pc.Close()
+	r, err := NewResolver(s.pc.LocalAddr().String())
+	if err != nil {
+		t.Fatal(err)
+	}
+	r.Timeout, r.Retries = 100*time.Millisecond, 0
+	ctx := context.Background()
+
+	recs, err := r.Lookup(ctx, "example.test", TypeA)
+	if err != nil || len(recs) != 1 || recs[0].Value != "192.0.2.1" || recs[0].TTL != 60 {
+		t.Fatalf("Lookup(example.test) = %v, %v", recs, err)
+	}
+	if _, err := r.Lookup(ctx, "EXAMPLE.test.", TypeA); err != nil || s.Queries() != 1 {
+		t.Fatalf("cached lookup: %v, %d queries", err, s.Queries())
+	}
+
+	_, err = r.Lookup(ctx, "missing.test", TypeA)
+	var de *DNSError
+	if !errors.Is(err, ErrNXDomain) || !errors.As(err, &de) || de.Name != "missing.test." {
+		t.Fatalf("Lookup(missing.test) = %v", err)
+	}
+
+	start := time.Now()
+	_, err = r.Lookup(ctx, "slow.test", TypeA)
+	if !errors.Is(err, ErrDNSTimeout) {
+		t.Fatalf("Lookup(slow.test) = %v", err)
+	}
+	if d := time.Since(start); d > time.Second {
+		t.Fatalf("timeout took %v", d)
+	}
+}
+
+func TestNewResolverPort(t *testing.T) {
+	r, err := NewResolver("192.0.2.53")
+	if err != nil || r.Server != "192.0.2.53:53" {
+		t.Fatalf("NewResolver = %v, %v", r, err)
+	}
+}
+
+func TestResolverLookupAllPanic(t *testing.T) {
+	s := newFakeDNS(t)
+	defer s.pc.Close()
+	r, err := NewResolver(s.pc.LocalAddr().String())
+	if err != nil {
+		t.Fatal(err)
+	}
+	r.Timeout, r.Retries, r.Workers = 100*time.Millisecond, 0, 2
+	names := SliceIterator([]string{"example.test", "a.test", "b.test", "c.test"})
+	calls := 0
+	done := make(chan struct{})
+	go func() {
+		defer close(done)
+		r.LookupAll(context.Background(), names, TypeA, func(res DNSResult) {
+			calls++
+			if calls == 1 {
+				panic("boom")
+			}
+		})
+	}()
+	select {
+	case <-done:
+	case <-time.After(2 * time.Second):
+		t.Fatal("LookupAll blocked after fn panicked")
+	}
+	if calls != 4 {
+		t.Fatalf("fn called %d times, want 4", calls)
+	}
+}
+
+func TestResolverCacheEviction(t *testing.T) {
+	r, err := NewResolver("192.0.2.53")
+	if err != nil {
+		t.Fatal(err)
+	}
+	r.MaxCache = 100
+	past, future := time.Now().Add(-time.Second), time.Now().Add(time.Hour)
+	for i := 0; i < 1000; i++ {
+		r.cacheLocked(dnsCacheKey{fmt.Sprintf("old%d.test.", i), TypeA}, dnsCacheEntry{expires: past})
+	}
+	if len(r.cache) > r.MaxCache {
+		t.Fatalf("%d expired entries kept, MaxCache %d", len(r.cache), r.MaxCache)
+	}
+	for i := 0; i < 1000; i++ {
+		r.cacheLocked(dnsCacheKey{fmt.Sprintf("new%d.test.", i), TypeA}, dnsCacheEntry{expires: future})
+	}
+	if len(r.cache) > r.MaxCache {
+		t.Fatalf("%d live entries kept, MaxCache %d", len(r.cache), r.MaxCache)
+	}
+
+	r.MaxCache = 0
+	r.cache, r.swept = make(map[dnsCacheKey]dnsCacheEntry), 0
+	for i := 0; i < 10000; i++ {
+		r.cacheLocked(dnsCacheKey{fmt.Sprintf("old%d.test.", i), TypeA}, dnsCacheEntry{expires: past})
+	}
+	if len(r.cache) > 200 {
+		t.Fatalf("%d expired entries kept without MaxCache", len(r.cache))
+	}
+}
@@ -0,0 +1,38 @@
+package tools
+
+import "net"
+
+// systemNameserver returns the first DNS server of an adapter that is up.
+func systemNameserver() (string, error) {
+	adapters, err := adapterAddresses()
+	if err != nil {
+		return "", err
+	}
+	for _, aa := range adapters {
+		for dns := aa.FirstDnsServerAddress; dns != nil; dns = dns.Next {
+			ip := dns.Address.IP()
+			if ip == nil || ip.IsLinkLocalUnicast() || isDefaultSiteLocalDNS(ip) {
+				continue
+			}
+			return ip.String(), nil
+		}
+	}
+	return "", ErrNoNameserver
+}
+
+// siteLocalDNS are the deprecated placeholders Windows reports when IPv6
+// is enabled but no IPv6 DNS server is set.
+var siteLocalDNS = []net.IP{
+	net.ParseIP("fec0:0:0:ffff::1"),
+	net.ParseIP("fec0:0:0:ffff::2"),
+	net.ParseIP("fec0:0:0:ffff::3"),
+}
+
+func isDefaultSiteLocalDNS(ip net.IP) bool {
+	for _, s := range siteLocalDNS {
+		if ip.Equal(s) {
+			return true
+		}
+	}
+	return false
+}
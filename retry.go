@@ -0,0 +1,187 @@
+package tools
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"math"
+	"math/rand"
+	"net"
+	"net/http"
+	"strconv"
+	"syscall"
+	"time"
+)
+
+// RetryPolicy retries failed attempts with exponential backoff and full
+// jitter. A nil policy runs every task exactly once.
+type RetryPolicy struct {
+	MaxAttempts int              // attempts including the first one
+	BaseDelay   time.Duration    // backoff cap before the second attempt
+	MaxDelay    time.Duration    // upper bound of any delay, Retry-After included; 0 for none
+	Retryable   func(error) bool // nil means IsRetryable
+}
+
+// DefaultRetryPolicy suits network probes: 4 attempts, 500ms doubling to 30s.
+func DefaultRetryPolicy() *RetryPolicy {
+	return &RetryPolicy{MaxAttempts: 4, BaseDelay: 500 * time.Millisecond, MaxDelay: 30 * time.Second}
+}
+
+// Backoff returns how long to sleep after the given failed attempt (1-based):
+// a random duration up to BaseDelay*2^(attempt-1), capped at MaxDelay.
+func (rp *RetryPolicy) Backoff(attempt int) time.Duration {
+	ceiling := rp.BaseDelay
+	for i := 1; i < attempt; i++ {
+		if ceiling > math.MaxInt64/2 || rp.MaxDelay > 0 && ceiling >= rp.MaxDelay {
+			break
+		}
+		ceiling *= 2
+	}
+	if rp.MaxDelay > 0 && ceiling > rp.MaxDelay {
+		ceiling = rp.MaxDelay
+	}
+	if ceiling <= 0 {
+		return 0
+	}
+	return time.Duration(rand.Int63n(int64(ceiling) + 1))
+}
+
+// Do runs fn until it succeeds, fails with a non-retryable error, runs out
+// of attempts or ctx is done, and reports how many attempts were made.
+func (rp *RetryPolicy) Do(ctx context.Context, fn func(ctx context.Context) error) (int, error) {
+	attempts := 0
+	for {
+		attempts++
+		err := fn(ctx)
+		if ctx.Err() != nil {
+			return attempts, err
+		}
+		delay, retry := rp.retryDelay(attempts, err)
+		if !retry {
+			return attempts, err
+		}
+		timer := time.NewTimer(delay)
+		select {
+		case <-timer.C:
+		case <-ctx.Done():
+			timer.Stop()
+			return attempts, err
+		}
+	}
+}
+
+// retryDelay reports whether err from the given attempt (1-based) is worth
+// another one and how long to wait before it.
+func (rp *RetryPolicy) retryDelay(attempt int, err error) (time.Duration, bool) {
+	if err == nil || rp == nil || attempt >= rp.MaxAttempts {
+		return 0, false
+	}
+	retryable := rp.Retryable
+	if retryable == nil {
+		retryable = IsRetryable
+	}
+	if !retryable(err) {
+		return 0, false
+	}
+	delay := rp.Backoff(attempt)
+	if after, ok := RetryAfterOf(err); ok && after > delay {
+		delay = after
+	}
+	if rp.MaxDelay > 0 && delay > rp.MaxDelay {
+		delay = rp.MaxDelay
+	}
+	return delay, true
+}
+
+//================================================================================
+
+// RetryAfterError wraps an error with the delay the server asked for.
+type RetryAfterError struct {
+	Err   error
+	After time.Duration
+}
+
+func (e *RetryAfterError) Error() string {
+	return fmt.Sprintf("%v (retry after %v)", e.Err, e.After)
+}
+
+func (e *RetryAfterError) Unwrap() error { return e.Err }
+
+func (e *RetryAfterError) RetryAfter() time.Duration { return e.After }
+
+// StatusError is an HTTP response with an unexpected status code.
+type StatusError struct {
+	StatusCode int
+	After      time.Duration // from the Retry-After header, 0 if absent
+}
+
+// NewStatusError builds a StatusError from resp, reading its Retry-After header.
+func NewStatusError(resp *http.Response) *StatusError {
+	after, _ := ParseRetryAfter(resp.Header.Get("Retry-After"))
+	return &StatusError{StatusCode: resp.StatusCode, After: after}
+}
+
+func (e *StatusError) Error() string {
+	return fmt.Sprintf("unexpected status %d %s", e.StatusCode, http.StatusText(e.StatusCode))
+}
+
+func (e *StatusError) RetryAfter() time.Duration { return e.After }
+
+// ParseRetryAfter parses a Retry-After header, either delay seconds or an
+// HTTP date.
+func ParseRetryAfter(value string) (time.Duration, bool) {
+	if value == "" {
+		return 0, false
+	}
+	if secs, err := strconv.Atoi(value); err == nil && secs >= 0 {
+		return time.Duration(secs) * time.Second, true
+	}
+	if t, err := http.ParseTime(value); err == nil {
+		if d := time.Until(t); d > 0 {
+			return d, true
+		}
+		return 0, true
+	}
+	return 0, false
+}
+
+// RetryAfterOf returns the delay carried by err or anything it wraps.
+func RetryAfterOf(err error) (time.Duration, bool) {
+	var ra interface{ RetryAfter() time.Duration }
+	if errors.As(err, &ra) && ra.RetryAfter() > 0 {
+		return ra.RetryAfter(), true
+	}
+	return 0, false
+}
+
+// IsRetryable is the default classifier: timeouts, connection resets and
+// refusals, truncated responses, HTTP 429 and 5xx, and anything carrying a
+// Retry-After delay. Cancellation is never retried.
+func IsRetryable(err error) bool {
+	if err == nil || errors.Is(err, context.Canceled) {
+		return false
+	}
+	if _, ok := RetryAfterOf(err); ok {
+		return true
+	}
+	var se *StatusError
+	if errors.As(err, &se) {
+		return se.StatusCode == http.StatusTooManyRequests || se.StatusCode >= 500
+	}
+	var ne net.Error
+	if errors.As(err, &ne) && ne.Timeout() {
+		return true
+	}
+	targets := []error{
+		context.DeadlineExceeded, ErrDNSTimeout, io.ErrUnexpectedEOF,
+		syscall.ECONNRESET, syscall.ECONNREFUSED, syscall.ECONNABORTED, syscall.EPIPE,
+	}
+	for _, target := range append(targets, winsockErrors...) {
+		if errors.Is(err, target) {
+			return true
+		}
+	}
+	var temp interface{ Temporary() bool }
+	return errors.As(err, &temp) && temp.Temporary()
+}
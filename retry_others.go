@@ -0,0 +1,8 @@
+//go:build !windows
+// +build !windows
+
+package tools
+
+// winsockErrors is empty outside Windows, where the syscall errnos checked
+// by IsRetryable already cover these failures.
+var winsockErrors []error
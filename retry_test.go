@@ -0,0 +1,64 @@
+package tools
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"net/http"
+	"testing"
+	"time"
+)
+
+func TestIsRetryable(t *testing.T) {
+	for _, tc := range []struct {
+		err  error
+		want bool
+	}{
+		{errors.New("bad request"), false},
+		{context.Canceled, false},
+		{fmt.Errorf("probe: %w", context.DeadlineExceeded), true},
+		{&StatusError{StatusCode: http.StatusTooManyRequests}, true},
+		{&StatusError{StatusCode: http.StatusServiceUnavailable}, true},
+		{&StatusError{StatusCode: http.StatusNotFound}, false},
+		{&RetryAfterError{Err: errors.New("slow down"), After: time.Second}, true},
+	} {
+		if got := IsRetryable(tc.err); got != tc.want {
+			t.Errorf("IsRetryable(%v) = %v, want %v", tc.err, got, tc.want)
+		}
+	}
+}
+
+func TestRetryPolicyDo(t *testing.T) {
+	rp := &RetryPolicy{MaxAttempts: 3, BaseDelay: time.Millisecond}
+	calls := 0
+	attempts, err := rp.Do(context.Background(), func(ctx context.Context) error {
+		calls++
+		if calls < 3 {
+			return &StatusError{StatusCode: http.StatusBadGateway}
+		}
+		return nil
+	})
+	if err != nil || attempts != 3 {
+		t.Fatalf("Do() = %d, %v", attempts, err)
+	}
+
+	attempts, err = rp.Do(context.Background(), func(ctx context.Context) error {
+		return errors.New("permanent")
+	})
+	if err == nil || attempts != 1 {
+		t.Fatalf("non-retryable error: Do() = %d, %v", attempts, err)
+	}
+}
+
+func TestRetryAfterCappedAtMaxDelay(t *testing.T) {
+	rp := &RetryPolicy{MaxAttempts: 2, BaseDelay: time.Millisecond, MaxDelay: 20 * time.Millisecond}
+	throttled := &StatusError{StatusCode: http.StatusTooManyRequests, After: time.Hour}
+	if delay, ok := rp.retryDelay(1, throttled); !ok || delay != rp.MaxDelay {
+		t.Fatalf("retryDelay() = %v, %v", delay, ok)
+	}
+	start := time.Now()
+	attempts, _ := rp.Do(context.Background(), func(ctx context.Context) error { return throttled })
+	if d := time.Since(start); attempts != 2 || d > time.Second {
+		t.Fatalf("Do() made %d attempts in %v", attempts, d)
+	}
+}
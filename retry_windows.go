@@ -0,0 +1,10 @@
+package tools
+
+import "golang.org/x/sys/windows"
+
+// winsockErrors are the socket errors IsRetryable retries on Windows, where
+// Winsock reports WSAECONNRESET and friends rather than the POSIX errnos.
+var winsockErrors = []error{
+	windows.WSAECONNRESET, windows.WSAECONNABORTED, windows.WSAECONNREFUSED,
+	windows.WSAENETRESET, windows.WSAETIMEDOUT,
+}
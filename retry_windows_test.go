@@ -0,0 +1,21 @@
+package tools
+
+import (
+	"net"
+	"os"
+	"testing"
+
+	"golang.org/x/sys/windows"
+)
+
+func TestIsRetryableWinsock(t *testing.T) {
+	for _, errno := range []error{windows.WSAECONNRESET, windows.WSAECONNREFUSED, windows.WSAECONNABORTED} {
+		err := &net.OpError{Op: "read", Net: "tcp", Err: os.NewSyscallError("wsarecv", errno)}
+		if !IsRetryable(err) {
+			t.Errorf("IsRetryable(%v) = false", err)
+		}
+	}
+	if IsRetryable(os.NewSyscallError("wsarecv", windows.WSAEACCES)) {
+		t.Error("WSAEACCES is retryable")
+	}
+}
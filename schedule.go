@@ -0,0 +1,125 @@
+package tools
+
+import (
+	"container/heap"
+	"time"
+)
+
+// taskHeap is a binary heap of tasks ordered by less.
+type taskHeap struct {
+	tasks []*Task
+	less  func(a, b *Task) bool
+}
+
+func (h *taskHeap) Len() int           { return len(h.tasks) }
+func (h *taskHeap) Less(i, j int) bool { return h.less(h.tasks[i], h.tasks[j]) }
+func (h *taskHeap) Swap(i, j int)      { h.tasks[i], h.tasks[j] = h.tasks[j], h.tasks[i] }
+func (h *taskHeap) Push(x interface{}) { h.tasks = append(h.tasks, x.(*Task)) }
+
+func (h *taskHeap) Pop() interface{} {
+	n := len(h.tasks) - 1
+	t := h.tasks[n]
+	h.tasks[n] = nil
+	h.tasks = h.tasks[:n]
+	return t
+}
+
+func (h *taskHeap) push(t *Task) { heap.Push(h, t) }
+func (h *taskHeap) pop() *Task   { return heap.Pop(h).(*Task) }
+func (h *taskHeap) peek() *Task  { return h.tasks[0] }
+
+// byPriority runs higher priorities first, then retries ahead of fresh
+// tasks, then in submit order.
+func byPriority(a, b *Task) bool {
+	if a.Priority != b.Priority {
+		return a.Priority > b.Priority
+	}
+	if (a.attempts > 0) != (b.attempts > 0) {
+		return a.attempts > 0
+	}
+	return a.ID < b.ID
+}
+
+// byTime orders delayed tasks by due time.
+func byTime(a, b *Task) bool {
+	if !a.At.Equal(b.At) {
+		return a.At.Before(b.At)
+	}
+	return a.ID < b.ID
+}
+
+// scheduler holds the tasks of a WorkPool that wait for a worker: ready
+// ones in a priority heap and ones due later in a heap by time. wake is
+// called once the earliest delayed task is due.
+type scheduler struct {
+	ready   taskHeap
+	delayed taskHeap
+	timer   *time.Timer
+	timerAt time.Time
+	wake    func()
+}
+
+func newScheduler(wake func()) *scheduler {
+	return &scheduler{
+		ready:   taskHeap{less: byPriority},
+		delayed: taskHeap{less: byTime},
+		wake:    wake,
+	}
+}
+
+func (s *scheduler) push(t *Task, now time.Time) {
+	if t.At.After(now) {
+		s.delayed.push(t)
+	} else {
+		s.ready.push(t)
+	}
+}
+
+// promote moves the delayed tasks that are due to the ready heap.
+func (s *scheduler) promote(now time.Time) {
+	for s.delayed.Len() > 0 && !s.delayed.peek().At.After(now) {
+		s.ready.push(s.delayed.pop())
+	}
+}
+
+// next returns the most urgent ready task, or nil.
+func (s *scheduler) next() *Task {
+	if s.ready.Len() == 0 {
+		return nil
+	}
+	return s.ready.pop()
+}
+
+// arm makes sure wake runs when the earliest delayed task is due; call it
+// after promote.
+func (s *scheduler) arm(now time.Time) {
+	if s.delayed.Len() == 0 {
+		return
+	}
+	at := s.delayed.peek().At
+	if s.timer != nil && !at.Before(s.timerAt) {
+		return
+	}
+	if s.timer != nil {
+		s.timer.Stop()
+	}
+	s.timer, s.timerAt = time.AfterFunc(at.Sub(now), s.wake), at
+}
+
+// fired forgets the timer that just called wake.
+func (s *scheduler) fired() {
+	if s.timer != nil && !time.Now().Before(s.timerAt) {
+		s.timer = nil
+	}
+}
+
+// clear drops every task and returns how many there were.
+func (s *scheduler) clear() int {
+	n := s.ready.Len() + s.delayed.Len()
+	s.ready.tasks, s.delayed.tasks = nil, nil
+	if s.timer != nil {
+		s.timer.Stop()
+		s.timer = nil
+	}
+	return n
+}
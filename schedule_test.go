@@ -0,0 +1,138 @@
+package tools
+
+import (
+	"context"
+	"errors"
+	"reflect"
+	"sync"
+	"testing"
+	"time"
+)
+
+func TestWorkPoolPriorityOrder(t *testing.T) {
+	p := NewWorkPool(context.Background(), 1)
+	gate := make(chan struct{})
+	p.Submit(func(ctx context.Context) error { <-gate; return nil })
+	var order []string
+	for _, task := range []struct {
+		key      string
+		priority int
+	}{{"low1", 0}, {"high", 10}, {"low2", 0}, {"mid", 5}, {"neg", -1}, {"high2", 10}} {
+		p.SubmitTask(&Task{Key: task.key, Priority: task.priority, Run: func(ctx context.Context) error { return nil }})
+	}
+	p.OnResult(func(res TaskResult) {
+		if res.Key != "" {
+			order = append(order, res.Key)
+		}
+	})
+	close(gate)
+	if err := p.Wait(); err != nil {
+		t.Fatal(err)
+	}
+	want := []string{"high", "high2", "mid", "low1", "low2", "neg"}
+	if !reflect.DeepEqual(order, want) {
+		t.Fatalf("order = %v, want %v", order, want)
+	}
+}
+
+func TestWorkPoolDelayedTasks(t *testing.T) {
+	p := NewWorkPool(context.Background(), 2)
+	start := time.Now()
+	var mu sync.Mutex
+	var order []string
+	started := make(map[string]time.Duration)
+	run := func(key string) func(ctx context.Context) error {
+		return func(ctx context.Context) error {
+			mu.Lock()
+			order = append(order, key)
+			started[key] = time.Since(start)
+			mu.Unlock()
+			return nil
+		}
+	}
+	p.SubmitTask(&Task{Key: "later", At: start.Add(80 * time.Millisecond), Priority: 100, Run: run("later")})
+	p.SubmitAfter(40*time.Millisecond, run("soon"))
+	p.SubmitTask(&Task{Key: "now", Run: run("now")})
+	if p.Delayed() != 2 {
+		t.Fatalf("Delayed() = %d", p.Delayed())
+	}
+	if err := p.Wait(); err != nil {
+		t.Fatal(err)
+	}
+	if want := []string{"now", "soon", "later"}; !reflect.DeepEqual(order, want) {
+		t.Fatalf("order = %v, want %v", order, want)
+	}
+	if started["soon"] < 40*time.Millisecond || started["later"] < 80*time.Millisecond {
+		t.Fatalf("started early: %v", started)
+	}
+	if time.Since(start) > time.Second {
+		t.Fatalf("Wait took %v", time.Since(start))
+	}
+}
+
+func TestWorkPoolCancelDropsDelayedTasks(t *testing.T) {
+	p := NewWorkPool(context.Background(), 1)
+	ran := false
+	p.SubmitTask(&Task{At: time.Now().Add(time.Hour), Run: func(ctx context.Context) error { ran = true; return nil }})
+	time.AfterFunc(20*time.Millisecond, p.Cancel)
+	done := make(chan error, 1)
+	go func() { done <- p.Wait() }()
+	select {
+	case <-done:
+	case <-time.After(time.Second):
+		t.Fatal("Wait blocked on a delayed task after Cancel")
+	}
+	if ran || p.Delayed() != 0 {
+		t.Fatalf("ran = %v, Delayed() = %d", ran, p.Delayed())
+	}
+}
+
+func TestWorkPoolRetryFreesWorker(t *testing.T) {
+	p := NewWorkPool(context.Background(), 1)
+	p.UseRetry(&RetryPolicy{MaxAttempts: 3})
+	start := time.Now()
+	var mu sync.Mutex
+	var order []string
+	log := func(s string) int {
+		mu.Lock()
+		defer mu.Unlock()
+		order = append(order, s)
+		return len(order)
+	}
+	gate := make(chan struct{})
+	p.Submit(func(ctx context.Context) error { <-gate; return nil })
+	p.SubmitTask(&Task{Key: "throttled", Run: func(ctx context.Context) error {
+		if log("throttled") == 1 {
+			return &RetryAfterError{Err: errors.New("429"), After: 50 * time.Millisecond}
+		}
+		return nil
+	}})
+	p.SubmitTask(&Task{Key: "flaky", Run: func(ctx context.Context) error {
+		if log("flaky") == 2 {
+			return &StatusError{StatusCode: 503}
+		}
+		return nil
+	}})
+	for _, key := range []string{"bulk1", "bulk2"} {
+		key := key
+		p.SubmitTask(&Task{Key: key, Run: func(ctx context.Context) error { log(key); return nil }})
+	}
+	attempts := make(map[string]int)
+	p.OnResult(func(res TaskResult) { attempts[res.Key] = res.Attempts })
+	close(gate)
+	if err := p.Wait(); err != nil {
+		t.Fatal(err)
+	}
+	// flaky is retried ahead of the bulk tasks; throttled waits out its
+	// Retry-After without holding the only worker
+	want := []string{"throttled", "flaky", "flaky", "bulk1", "bulk2", "throttled"}
+	if !reflect.DeepEqual(order, want) {
+		t.Fatalf("order = %v, want %v", order, want)
+	}
+	if attempts["throttled"] != 2 || attempts["flaky"] != 2 || attempts["bulk1"] != 1 {
+		t.Fatalf("attempts = %v", attempts)
+	}
+	if d := time.Since(start); d < 50*time.Millisecond {
+		t.Fatalf("retried after %v, before Retry-After", d)
+	}
+}
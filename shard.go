@@ -0,0 +1,50 @@
+package tools
+
+import (
+	"fmt"
+	"hash/fnv"
+	"math/bits"
+)
+
+// Shard keeps the targets of it that belong to shard index (0-based) out of
+// total. Every box running the same spec with a different index scans a
+// disjoint part of roughly total/N targets, whatever the source order is.
+func Shard(it Iterator, index, total int) (Iterator, error) {
+	return ShardSeed(it, index, total, 0)
+}
+
+// ShardSeed is Shard with a seed, so separate jobs over the same scope
+// can be split differently.
+func ShardSeed(it Iterator, index, total int, seed int64) (Iterator, error) {
+	if total < 1 || index < 0 || index >= total {
+		return nil, fmt.Errorf("invalid shard %d/%d", index, total)
+	}
+	return &shardIterator{src: it, index: index, total: total, seed: seed}, nil
+}
+
+// ShardOf returns the shard a target is assigned to.
+func ShardOf(target string, total int, seed int64) int {
+	name, _ := normalizeTarget(target)
+	h := fnv.New64a()
+	h.Write([]byte(name))
+	hi, _ := bits.Mul64(splitmix64(h.Sum64()^uint64(seed)), uint64(total))
+	return int(hi)
+}
+
+type shardIterator struct {
+	src          Iterator
+	index, total int
+	seed         int64
+}
+
+func (it *shardIterator) Next() (string, bool) {
+	for {
+		s, ok := it.src.Next()
+		if !ok {
+			return "", false
+		}
+		if ShardOf(s, it.total, it.seed) == it.index {
+			return s, true
+		}
+	}
+}
@@ -0,0 +1,154 @@
+package tools
+
+import (
+	"context"
+	"errors"
+	"log"
+	"os"
+	"os/signal"
+	"sync"
+	"syscall"
+	"time"
+)
+
+// ErrShutdown is the Cause of a WorkPool run stopped by Drain.
+var ErrShutdown = errors.New("shutting down")
+
+// Shutdown turns Ctrl-C and SIGTERM into an orderly stop. On the first
+// signal it cancels Context, so feeders stop submitting, drains the watched
+// pools for up to Grace, then flushes the registered writers and
+// checkpoints. A second signal exits at once with status 130.
+//
+//	sd := tools.NewShutdown(30 * time.Second)
+//	defer sd.Close()
+//	sd.Watch(pool)
+//	sd.Flush(out) // a *bufio.Writer, a *Checkpoint, ...
+type Shutdown struct {
+	Grace time.Duration
+
+	ctx    context.Context
+	cancel context.CancelFunc
+	sigs   chan os.Signal
+	once   sync.Once
+	done   chan struct{}
+	err    error
+
+	mu       sync.Mutex
+	drainers []func(time.Duration) bool
+	atExit   []func() error
+}
+
+// NewShutdown starts listening for signals.
+func NewShutdown(grace time.Duration) *Shutdown {
+	s := &Shutdown{
+		Grace: grace,
+		sigs:  make(chan os.Signal, 2),
+		done:  make(chan struct{}),
+	}
+	s.ctx, s.cancel = context.WithCancel(context.Background())
+	signal.Notify(s.sigs, os.Interrupt, syscall.SIGTERM)
+	go s.listen()
+	return s
+}
+
+func (s *Shutdown) listen() {
+	triggered := false
+	for {
+		select {
+		case sig := <-s.sigs:
+			if triggered {
+				log.Printf("%v again, quitting without waiting", sig)
+				os.Exit(130)
+			}
+			triggered = true
+			log.Printf("%v, shutting down: waiting up to %v for running tasks, repeat to quit now", sig, s.Grace)
+			go s.Trigger()
+		case <-s.done:
+			return
+		}
+	}
+}
+
+// Context is cancelled as soon as the shutdown starts. Stop feeding tasks
+// when it is done, but do not derive a watched pool's context from it, or
+// its running tasks are cancelled without the grace period.
+func (s *Shutdown) Context() context.Context { return s.ctx }
+
+// Watch drains p on shutdown and logs the tasks it had to abandon.
+func (s *Shutdown) Watch(p *WorkPool) {
+	s.mu.Lock()
+	s.drainers = append(s.drainers, func(timeout time.Duration) bool {
+		if !p.Drain(timeout) {
+			return false
+		}
+		if tasks := p.Abandoned(); len(tasks) > 0 {
+			log.Print(abandonedSummary(tasks))
+		}
+		return true
+	})
+	s.mu.Unlock()
+}
+
+// WatchGroup waits for wg on shutdown, e.g. the Wg of a Pool.
+func (s *Shutdown) WatchGroup(wg *sync.WaitGroup) {
+	s.mu.Lock()
+	s.drainers = append(s.drainers, func(timeout time.Duration) bool { return WaitTimeout(wg, timeout) })
+	s.mu.Unlock()
+}
+
+// Flush registers f, e.g. a *bufio.Writer or a *Checkpoint, to be flushed
+// once the watched work has stopped.
+func (s *Shutdown) Flush(f interface{ Flush() error }) {
+	s.AtExit(f.Flush)
+}
+
+// AtExit registers fn to run after the watched work has stopped, in
+// registration order.
+func (s *Shutdown) AtExit(fn func() error) {
+	s.mu.Lock()
+	s.atExit = append(s.atExit, fn)
+	s.mu.Unlock()
+}
+
+// Trigger starts the shutdown as if a signal had arrived and returns once
+// it is complete, e.g. to stop a run on a fatal error.
+func (s *Shutdown) Trigger() error {
+	s.once.Do(func() { s.finish(true) })
+	<-s.done
+	return s.err
+}
+
+// Close runs the AtExit functions if no shutdown happened, or waits for the
+// running one, and stops listening for signals. It returns the first error
+// of the AtExit functions.
+func (s *Shutdown) Close() error {
+	s.once.Do(func() { s.finish(false) })
+	<-s.done
+	signal.Stop(s.sigs)
+	return s.err
+}
+
+func (s *Shutdown) finish(drain bool) {
+	defer close(s.done)
+	s.cancel()
+	s.mu.Lock()
+	drainers, atExit := s.drainers, s.atExit
+	s.mu.Unlock()
+
+	if drain {
+		deadline := time.Now().Add(s.Grace)
+		for _, d := range drainers {
+			if d(time.Until(deadline)) {
+				log.Printf("grace period of %v is over, cancelling running tasks", s.Grace)
+			}
+		}
+	}
+	for _, fn := range atExit {
+		if err := fn(); err != nil {
+			log.Println("shutdown:", err)
+			if s.err == nil {
+				s.err = err
+			}
+		}
+	}
+}
@@ -0,0 +1,398 @@
+package tools
+
+import (
+	"errors"
+	"fmt"
+	"io/ioutil"
+	"net"
+	"strconv"
+	"strings"
+)
+
+var (
+	ErrBadCIDR     = errors.New("invalid CIDR")
+	ErrBadHostname = errors.New("invalid hostname")
+)
+
+// TargetError reports a single token of a target spec that could not be parsed.
+type TargetError struct {
+	Token string
+	Err   error
+}
+
+func (e *TargetError) Error() string { return fmt.Sprintf("target %q: %v", e.Token, e.Err) }
+func (e *TargetError) Unwrap() error { return e.Err }
+
+// TargetErrors collects every bad token of a spec.
+type TargetErrors []*TargetError
+
+func (e TargetErrors) Error() string {
+	msgs := make([]string, len(e))
+	for i, err := range e {
+		msgs[i] = err.Error()
+	}
+	return strings.Join(msgs, "; ")
+}
+
+//================================================================================
+
+// targetSpec is one parsed token: a CIDR, a range, an octet pattern or a hostname.
+type targetSpec interface {
+	iter() specIterator
+	contains(name string, ip net.IP) bool
+}
+
+type specIterator interface {
+	next() (string, net.IP, bool)
+}
+
+type rangeSpec struct {
+	first, last uint128
+	v4          bool
+}
+
+func (s *rangeSpec) iter() specIterator {
+	return hostSpecIterator{&HostIterator{cur: s.first, last: s.last, v4: s.v4}}
+}
+
+func (s *rangeSpec) contains(name string, ip net.IP) bool {
+	if ip == nil {
+		return false
+	}
+	n, v4, err := ipToUint128(ip)
+	return err == nil && v4 == s.v4 && s.first.cmp(n) <= 0 && n.cmp(s.last) <= 0
+}
+
+type hostSpecIterator struct{ it *HostIterator }
+
+func (h hostSpecIterator) next() (string, net.IP, bool) {
+	ip, ok := h.it.NextIP()
+	if !ok {
+		return "", nil, false
+	}
+	return ip.String(), ip, true
+}
+
+// octetSpec is an nmap style IPv4 pattern such as 10.0.*.1 or 172.16.3.1-20,
+// each octet running from lo to hi.
+type octetSpec struct {
+	lo, hi [4]byte
+}
+
+func (s *octetSpec) iter() specIterator { return &octetIterator{s: s, cur: s.lo} }
+
+func (s *octetSpec) contains(name string, ip net.IP) bool {
+	v4 := ip.To4()
+	if v4 == nil {
+		return false
+	}
+	for i := range v4 {
+		if v4[i] < s.lo[i] || v4[i] > s.hi[i] {
+			return false
+		}
+	}
+	return true
+}
+
+type octetIterator struct {
+	s    *octetSpec
+	cur  [4]byte
+	done bool
+}
+
+func (it *octetIterator) next() (string, net.IP, bool) {
+	if it.done {
+		return "", nil, false
+	}
+	ip := net.IPv4(it.cur[0], it.cur[1], it.cur[2], it.cur[3]).To4()
+	i := 3
+	for ; i >= 0; i-- {
+		if it.cur[i] < it.s.hi[i] {
+			it.cur[i]++
+			break
+		}
+		it.cur[i] = it.s.lo[i]
+	}
+	it.done = i < 0
+	return ip.String(), ip, true
+}
+
+type nameSpec struct {
+	name string
+}
+
+func (s *nameSpec) iter() specIterator { return &nameIterator{name: s.name} }
+
+func (s *nameSpec) contains(name string, ip net.IP) bool { return ip == nil && name == s.name }
+
+type nameIterator struct {
+	name string
+	done bool
+}
+
+func (it *nameIterator) next() (string, net.IP, bool) {
+	if it.done {
+		return "", nil, false
+	}
+	it.done = true
+	return it.name, nil, true
+}
+
+//================================================================================
+
+// Targets is a parsed target spec. It keeps the tokens rather than the
+// addresses, so iterating a /8 costs no more memory than iterating a /32.
+type Targets struct {
+	include []targetSpec
+	exclude []targetSpec
+}
+
+// ParseTargets parses a comma, whitespace or newline separated target spec:
+// addresses, CIDRs, a.b.c.d-e.f.g.h and 172.16.3.1-20 ranges, 10.0.*.1 octet
+// patterns, hostnames and IPv6 literals. Tokens starting with ! and the list
+// following --exclude (or --exclude=) are exclusions; # starts a comment.
+// Bad tokens are reported together as TargetErrors while the valid ones are
+// still kept in the returned Targets.
+func ParseTargets(spec string) (*Targets, error) {
+	t := new(Targets)
+	return t, t.Add(spec)
+}
+
+// ParseTargetFile parses a job file with ParseTargets.
+func ParseTargetFile(path string) (*Targets, error) {
+	data, err := ioutil.ReadFile(path)
+	if err != nil {
+		return nil, err
+	}
+	return ParseTargets(string(data))
+}
+
+// Add parses spec and appends its targets and exclusions.
+func (t *Targets) Add(spec string) error {
+	var errs TargetErrors
+	exclude := false
+	for _, line := range strings.Split(spec, "\n") {
+		if i := strings.IndexByte(line, '#'); i >= 0 {
+			line = line[:i]
+		}
+		for _, field := range strings.Fields(line) {
+			if field == "--exclude" {
+				exclude = true
+				continue
+			}
+			excludeField := exclude
+			exclude = false
+			if strings.HasPrefix(field, "--exclude=") {
+				field = strings.TrimPrefix(field, "--exclude=")
+				excludeField = true
+			}
+			for _, token := range strings.Split(field, ",") {
+				if token == "" {
+					continue
+				}
+				neg := excludeField
+				if strings.HasPrefix(token, "!") {
+					token = token[1:]
+					neg = true
+				}
+				s, err := parseTarget(token)
+				if err != nil {
+					errs = append(errs, &TargetError{Token: token, Err: err})
+					continue
+				}
+				if neg {
+					t.exclude = append(t.exclude, s)
+				} else {
+					t.include = append(t.include, s)
+				}
+			}
+		}
+	}
+	if len(errs) > 0 {
+		return errs
+	}
+	return nil
+}
+
+// Exclude parses spec and adds all of its tokens as exclusions.
+func (t *Targets) Exclude(spec string) error {
+	var errs TargetErrors
+	for _, token := range strings.FieldsFunc(spec, isTargetSeparator) {
+		s, err := parseTarget(strings.TrimPrefix(token, "!"))
+		if err != nil {
+			errs = append(errs, &TargetError{Token: token, Err: err})
+			continue
+		}
+		t.exclude = append(t.exclude, s)
+	}
+	if len(errs) > 0 {
+		return errs
+	}
+	return nil
+}
+
+func isTargetSeparator(r rune) bool {
+	return r == ',' || r == ' ' || r == '\t' || r == '\n' || r == '\r'
+}
+
+// Contains reports whether target (an address or hostname) would be yielded.
+func (t *Targets) Contains(target string) bool {
+	name, ip := normalizeTarget(target)
+	return t.includes(len(t.include), name, ip) && !t.excludes(name, ip)
+}
+
+func (t *Targets) includes(n int, name string, ip net.IP) bool {
+	for _, s := range t.include[:n] {
+		if s.contains(name, ip) {
+			return true
+		}
+	}
+	return false
+}
+
+func (t *Targets) excludes(name string, ip net.IP) bool {
+	for _, s := range t.exclude {
+		if s.contains(name, ip) {
+			return true
+		}
+	}
+	return false
+}
+
+// Iter returns a fresh de-duplicated stream over the targets, in spec order.
+func (t *Targets) Iter() Iterator {
+	return &targetIterator{t: t}
+}
+
+type targetIterator struct {
+	t   *Targets
+	i   int
+	cur specIterator
+}
+
+func (it *targetIterator) Next() (string, bool) {
+	for it.i < len(it.t.include) {
+		if it.cur == nil {
+			it.cur = it.t.include[it.i].iter()
+		}
+		name, ip, ok := it.cur.next()
+		if !ok {
+			it.cur = nil
+			it.i++
+			continue
+		}
+		// skip exclusions and targets an earlier token already produced
+		if it.t.includes(it.i, name, ip) || it.t.excludes(name, ip) {
+			continue
+		}
+		return name, true
+	}
+	return "", false
+}
+
+//================================================================================
+
+func normalizeTarget(s string) (string, net.IP) {
+	if ip := net.ParseIP(s); ip != nil {
+		return ip.String(), ip
+	}
+	return strings.ToLower(strings.TrimSuffix(s, ".")), nil
+}
+
+func parseTarget(token string) (targetSpec, error) {
+	if strings.Contains(token, "/") {
+		_, ipnet, err := net.ParseCIDR(token)
+		if err != nil {
+			return nil, ErrBadCIDR
+		}
+		first, last, v4 := prefixBounds(ipnet, false)
+		return &rangeSpec{first, last, v4}, nil
+	}
+	if ip := net.ParseIP(token); ip != nil {
+		n, v4, _ := ipToUint128(ip)
+		return &rangeSpec{n, n, v4}, nil
+	}
+	if i := strings.IndexByte(token, '-'); i > 0 && net.ParseIP(token[:i]) != nil && net.ParseIP(token[i+1:]) != nil {
+		first, v4, _ := parseAddr(token[:i])
+		last, lastV4, _ := parseAddr(token[i+1:])
+		if v4 != lastV4 || first.cmp(last) > 0 {
+			return nil, ErrBadRange
+		}
+		return &rangeSpec{first, last, v4}, nil
+	}
+	if strings.Contains(token, ":") {
+		if strings.Contains(token, "-") {
+			return nil, ErrBadRange
+		}
+		return nil, ErrBadAddress
+	}
+	if s, ok, err := parseOctets(token); ok {
+		return s, err
+	}
+	if strings.Trim(token, "0123456789.-*") == "" {
+		return nil, ErrBadAddress
+	}
+	if !isHostname(token) {
+		return nil, ErrBadHostname
+	}
+	name, _ := normalizeTarget(token)
+	return &nameSpec{name}, nil
+}
+
+// parseOctets parses a dotted IPv4 pattern where each octet is a number,
+// a lo-hi range or *. ok is false when token does not look like one at all.
+func parseOctets(token string) (s *octetSpec, ok bool, err error) {
+	parts := strings.Split(token, ".")
+	if len(parts) != 4 {
+		return nil, false, nil
+	}
+	for _, part := range parts {
+		if part != "*" && strings.Trim(part, "0123456789-") != "" {
+			return nil, false, nil
+		}
+	}
+	s = new(octetSpec)
+	for i, part := range parts {
+		if part == "*" {
+			s.lo[i], s.hi[i] = 0, 255
+			continue
+		}
+		lo, hi := part, part
+		if j := strings.IndexByte(part, '-'); j >= 0 {
+			lo, hi = part[:j], part[j+1:]
+		}
+		l, err1 := strconv.ParseUint(lo, 10, 8)
+		h, err2 := strconv.ParseUint(hi, 10, 8)
+		if err1 != nil || err2 != nil {
+			return nil, true, ErrBadAddress
+		}
+		if l > h {
+			return nil, true, ErrBadRange
+		}
+		s.lo[i], s.hi[i] = byte(l), byte(h)
+	}
+	return s, true, nil
+}
+
+func isHostname(s string) bool {
+	s = strings.TrimSuffix(s, ".")
+	if s == "" || len(s) > 253 {
+		return false
+	}
+	letter := false
+	for _, label := range strings.Split(s, ".") {
+		if label == "" || len(label) > 63 || label[0] == '-' || label[len(label)-1] == '-' {
+			return false
+		}
+		for _, c := range label {
+			switch {
+			case c >= 'a' && c <= 'z', c >= 'A' && c <= 'Z':
+				letter = true
+			case c >= '0' && c <= '9', c == '-', c == '_':
+			default:
+				return false
+			}
+		}
+	}
+	return letter
+}
@@ -10,7 +10,6 @@ import (
 	"crypto/sha256"
 	"encoding/base64"
 	"encoding/binary"
-	"encoding/csv"
 	"encoding/hex"
 	"encoding/json"
 	"errors"
@@ -53,27 +52,14 @@ func ShowBanner() {
 }
 
 //================================================================================
-//CIDR to ip range
-func inc(ip net.IP) {
-	for j := len(ip) - 1; j >= 0; j-- {
-		ip[j]++
-		if ip[j] > 0 {
-			break
-		}
-	}
-}
+//CIDR to ip range, without network and broadcast address.
+//Prefer NewHostIterator for large prefixes.
 func Hosts(cidr string) ([]string, error) {
-	ip, ipnet, err := net.ParseCIDR(cidr)
+	it, err := NewHostIterator(cidr, true)
 	if err != nil {
 		return nil, err
 	}
-
-	var ips []string
-	for ip := ip.Mask(ipnet.Mask); ipnet.Contains(ip); inc(ip) {
-		ips = append(ips, ip.String())
-	}
-	// remove network address and broadcast address
-	return ips[1 : len(ips)-1], nil
+	return Collect(it), nil
 }
 
 //================================================================================
@@ -81,6 +67,9 @@ func Hosts(cidr string) ([]string, error) {
 type Pool struct {
 	Queue chan int
 	Wg    *sync.WaitGroup
+
+	mu     sync.Mutex
+	panics []*PanicError
 }
 
 func NewPool(cap, total int) *Pool {
@@ -104,26 +93,49 @@ func (p *Pool) DelOne() {
 	p.Wg.Done()
 }
 
+//Go waits for a free slot and runs fn on a new goroutine, calling DelOne
+//when it returns. A panic in fn is logged and kept for Panics instead of
+//killing the process, so Wg stays balanced. Like AddOne, it expects Wg to
+//already count fn.
+func (p *Pool) Go(fn func()) {
+	p.AddOne()
+	go func() {
+		defer p.DelOne()
+		if err := Safe(func() error { fn(); return nil }); err != nil {
+			pe := err.(*PanicError)
+			log.Printf("pool: %v\n%s", pe, pe.Stack)
+			p.mu.Lock()
+			p.panics = append(p.panics, pe)
+			p.mu.Unlock()
+		}
+	}()
+}
+
+//Panics returns the panics recovered by Go so far.
+func (p *Pool) Panics() []*PanicError {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	return append([]*PanicError(nil), p.panics...)
+}
+
 //================================================================================
-//read csv via colums(int)
+//read csv via colums(int), short rows give "".
+//Use OpenCSV to stream large files or look columns up by name.
 func Read_csv(path string, columns int) []string {
-	file, err := os.Open(path)
+	reader, err := OpenCSV(path, CSVOptions{})
 	if err != nil {
 		fmt.Println("Error:", err)
 		return nil
 	}
-	defer file.Close()
-	reader := csv.NewReader(file)
+	defer reader.Close()
 	var list []string
-	for {
-		record, err := reader.Read()
-		if err == io.EOF {
-			break
-		} else if err != nil {
-			fmt.Println("Error:", err)
-			return nil
-		}
-		list = append(list, record[columns])
+	err = reader.Each(func(row CSVRow) error {
+		list = append(list, row.Index(columns))
+		return nil
+	})
+	if err != nil {
+		fmt.Println("Error:", err)
+		return nil
 	}
 	return list
 }
@@ -309,8 +321,7 @@ func Post_json(url string, header map[string]string, song map[string]interface{}
 		request.Header.Set(k, v)
 	}
 	request.Header.Set("Content-Type", "application/json;charset=UTF-8")
-	client := http.Client{}
-	resp, err := client.Do(request)
+	resp, err := HTTPClient.Do(request)
 	if err != nil {
 		fmt.Println(err.Error())
 		return "0"
@@ -413,7 +424,8 @@ func Unicode2utf8(source string) string {
 }
 
 //================================================================================
-func Unicode2Gbk(ustr string) string {
+//decode \uXXXX escapes; a malformed escape is returned as an error.
+func Unicode2Gbk(ustr string) (string, error) {
 	sUnicodev := strings.Split(ustr, "\\u")
 	var context string
 	for _, v := range sUnicodev {
@@ -422,11 +434,11 @@ func Unicode2Gbk(ustr string) string {
 		}
 		temp, err := strconv.ParseInt(v, 16, 32)
 		if err != nil {
-			panic(err)
+			return "", err
 		}
 		context += fmt.Sprintf("%c", temp)
 	}
-	return context
+	return context, nil
 }
 
 //================================================================================
@@ -478,32 +490,34 @@ func GetMacAddrs() (macAddrs []string) {
 }
 
 //================================================================================
-func GetPhysicalID() string {
+func GetPhysicalID() (string, error) {
 	var ids []string
 	if guid, err := getMachineGuid(); err != nil {
-		panic(err.Error())
+		return "", err
 	} else {
 		ids = append(ids, guid)
 	}
-	if cpuinfo, err := getCPUInfo(); err != nil && len(cpuinfo) > 0 {
-		panic(err.Error())
+	if cpuinfo, err := getCPUInfo(); err != nil {
+		return "", err
+	} else if len(cpuinfo) == 0 {
+		return "", errors.New("无法获取到CPU信息")
 	} else {
 		ids = append(ids, cpuinfo[0].VendorID+cpuinfo[0].PhysicalID)
 	}
 	if mac, err := getMACAddress(); err != nil {
-		panic(err.Error())
+		return "", err
 	} else {
 		ids = append(ids, mac)
 	}
 	sort.Strings(ids)
 	idsstr := strings.Join(ids, "|/|")
-	return GetMd5String(idsstr, true, true)
+	return GetMd5String(idsstr, true, true), nil
 }
 
 func getMACAddress() (string, error) {
 	netInterfaces, err := net.Interfaces()
 	if err != nil {
-		panic(err.Error())
+		return "", err
 	}
 	mac, macerr := "", errors.New("无法获取到正确的MAC地址")
 	for i := 0; i < len(netInterfaces); i++ {
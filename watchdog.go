@@ -0,0 +1,93 @@
+package tools
+
+import (
+	"fmt"
+	"log"
+	"sort"
+	"strings"
+	"time"
+)
+
+// RunningTask is a task that has started and not finished yet.
+type RunningTask struct {
+	ID    int
+	Key   string
+	Start time.Time
+}
+
+func (r RunningTask) String() string {
+	return fmt.Sprintf("%v running since %s (%v)", &Task{ID: r.ID, Key: r.Key},
+		r.Start.Format("15:04:05"), time.Since(r.Start).Round(time.Second))
+}
+
+// RunningTasks lists the running tasks, oldest first.
+func (p *WorkPool) RunningTasks() []RunningTask {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	return p.runningLocked()
+}
+
+func (p *WorkPool) runningLocked() []RunningTask {
+	tasks := make([]RunningTask, 0, len(p.active))
+	for _, r := range p.active {
+		tasks = append(tasks, r)
+	}
+	sort.Slice(tasks, func(i, j int) bool { return tasks[i].ID < tasks[j].ID })
+	return tasks
+}
+
+// Abandoned lists the tasks that were still running when Drain gave up on
+// them and cancelled their context.
+func (p *WorkPool) Abandoned() []RunningTask {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	return append([]RunningTask(nil), p.abandoned...)
+}
+
+// WatchHung calls report once for every task that runs longer than
+// threshold; a nil report logs the task. It catches tasks that ignore their
+// context and so outlive any timeout, which is why it keeps watching after
+// the run was cancelled until the last task is done or abandoned.
+func (p *WorkPool) WatchHung(threshold time.Duration, report func(RunningTask)) {
+	if report == nil {
+		report = func(r RunningTask) { log.Printf("hung: %v", r) }
+	}
+	interval := threshold / 4
+	if interval < 100*time.Millisecond {
+		interval = 100 * time.Millisecond
+	}
+	go func() {
+		ticker := time.NewTicker(interval)
+		defer ticker.Stop()
+		reported := make(map[int]bool)
+		for {
+			<-ticker.C
+			p.mu.Lock()
+			finished := p.ctx.Err() != nil && p.pending == 0
+			running := p.runningLocked()
+			p.mu.Unlock()
+			if finished {
+				return
+			}
+			still := make(map[int]bool, len(reported))
+			for _, r := range running {
+				if reported[r.ID] {
+					still[r.ID] = true
+				} else if time.Since(r.Start) > threshold {
+					still[r.ID] = true
+					report(r)
+				}
+			}
+			reported = still
+		}
+	}()
+}
+
+// abandonedSummary describes tasks left running at shutdown.
+func abandonedSummary(tasks []RunningTask) string {
+	lines := make([]string, len(tasks))
+	for i, r := range tasks {
+		lines[i] = "  " + r.String()
+	}
+	return fmt.Sprintf("%d tasks abandoned at shutdown:\n%s", len(tasks), strings.Join(lines, "\n"))
+}
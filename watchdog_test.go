@@ -0,0 +1,63 @@
+package tools
+
+import (
+	"context"
+	"sync"
+	"testing"
+	"time"
+)
+
+func TestWatchHungAfterCancel(t *testing.T) {
+	p := NewWorkPool(context.Background(), 2)
+	release := make(chan struct{})
+	p.SubmitTask(&Task{Key: "deaf", Run: func(ctx context.Context) error {
+		<-release // ignores ctx
+		return nil
+	}})
+	p.SubmitTask(&Task{Key: "polite", Run: func(ctx context.Context) error {
+		<-ctx.Done()
+		return ctx.Err()
+	}})
+
+	var mu sync.Mutex
+	var hung []RunningTask
+	p.WatchHung(50*time.Millisecond, func(r RunningTask) {
+		mu.Lock()
+		hung = append(hung, r)
+		mu.Unlock()
+	})
+	time.Sleep(10 * time.Millisecond)
+	p.Cancel()
+	time.Sleep(300 * time.Millisecond)
+
+	mu.Lock()
+	got := append([]RunningTask(nil), hung...)
+	mu.Unlock()
+	if len(got) != 1 || got[0].Key != "deaf" {
+		t.Fatalf("reported %v, want only the task ignoring its context", got)
+	}
+	close(release)
+	p.Wait()
+}
+
+func TestWorkPoolTaskTimeout(t *testing.T) {
+	p := NewWorkPool(context.Background(), 2)
+	p.SetTaskTimeout(20 * time.Millisecond)
+	p.SubmitTask(&Task{Key: "slow", Run: func(ctx context.Context) error {
+		<-ctx.Done()
+		return ctx.Err()
+	}})
+	p.SubmitTask(&Task{Key: "patient", Timeout: time.Second, Run: func(ctx context.Context) error {
+		select {
+		case <-time.After(50 * time.Millisecond):
+			return nil
+		case <-ctx.Done():
+			return ctx.Err()
+		}
+	}})
+	err := p.Wait()
+	pe, ok := err.(*PoolError)
+	if !ok || len(pe.Tasks) != 1 || pe.Tasks[0].Key != "slow" {
+		t.Fatalf("Wait() = %v", err)
+	}
+}
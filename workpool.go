@@ -0,0 +1,460 @@
+package tools
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"log"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+)
+
+// Task is a unit of work for a WorkPool. Queued tasks start by descending
+// Priority, retries ahead of fresh tasks, then in submit order; a task with
+// At in the future waits until then without holding a worker.
+type Task struct {
+	ID       int    // assigned by Submit
+	Key      string // optional name used in errors, e.g. the target address
+	Run      func(ctx context.Context) error
+	Retry    *RetryPolicy  // overrides the pool's policy
+	Priority int           // higher runs first, default 0
+	At       time.Time     // earliest start, zero for now
+	Timeout  time.Duration // per attempt, overrides the pool's default
+
+	attempts int       // made so far
+	start    time.Time // of the first attempt
+}
+
+func (t *Task) String() string {
+	if t.Key == "" {
+		return fmt.Sprintf("task %d", t.ID)
+	}
+	return fmt.Sprintf("task %d (%s)", t.ID, t.Key)
+}
+
+// TaskResult is reported for every finished task, see OnResult.
+type TaskResult struct {
+	ID       int
+	Key      string
+	Err      error
+	Attempts int
+	Start    time.Time
+	Duration time.Duration
+}
+
+// TaskError is the failure of a single task.
+type TaskError struct {
+	ID       int
+	Key      string
+	Err      error
+	Attempts int
+}
+
+func (e *TaskError) Error() string {
+	msg := (&Task{ID: e.ID, Key: e.Key}).String() + ": " + e.Err.Error()
+	if e.Attempts > 1 {
+		msg += fmt.Sprintf(" (after %d attempts)", e.Attempts)
+	}
+	return msg
+}
+
+func (e *TaskError) Unwrap() error { return e.Err }
+
+// PoolError lists every failed task of a run. Cause is set when the run was
+// cancelled, so errors.Is(err, context.Canceled) works on it. Abandoned
+// lists the tasks Drain stopped waiting for.
+type PoolError struct {
+	Tasks     []*TaskError
+	Cause     error
+	Abandoned []RunningTask
+}
+
+func (e *PoolError) Error() string {
+	var msgs []string
+	if e.Cause != nil {
+		msgs = append(msgs, "run cancelled: "+e.Cause.Error())
+	}
+	if len(e.Abandoned) > 0 {
+		msgs = append(msgs, fmt.Sprintf("%d tasks abandoned", len(e.Abandoned)))
+	}
+	if len(e.Tasks) > 0 {
+		msgs = append(msgs, fmt.Sprintf("%d tasks failed", len(e.Tasks)))
+	}
+	for _, t := range e.Tasks {
+		msgs = append(msgs, t.Error())
+	}
+	return strings.Join(msgs, "; ")
+}
+
+func (e *PoolError) Unwrap() error { return e.Cause }
+
+//================================================================================
+
+// WorkPool runs an open-ended stream of tasks on a bounded number of
+// goroutines. Unlike Pool it needs no task count up front, can be cancelled
+// through its context and collects task errors for Wait. A WorkPool serves
+// a single run: once Wait returns, further tasks are dropped.
+type WorkPool struct {
+	ctx    context.Context
+	cancel context.CancelFunc
+
+	mu      sync.Mutex
+	idle    *sync.Cond // broadcast when pending drops to zero
+	queue   *scheduler
+	workers int
+	running int
+	pending int // queued plus running
+	nextID  int
+	errs    []*TaskError
+	limiter Limiter
+	retry   *RetryPolicy
+	timeout time.Duration
+	hooks   []func(TaskResult)
+	active  map[int]RunningTask
+
+	checkpoint *Checkpoint
+	skipped    int
+
+	maxPanics int
+	panics    int
+	abort     error // why the pool cancelled or stopped itself
+	stopped   bool
+	abandoned []RunningTask
+}
+
+// NewWorkPool returns a pool running at most workers tasks at a time.
+// Cancelling ctx stops the run: queued tasks are dropped and running ones
+// see their context cancelled.
+func NewWorkPool(ctx context.Context, workers int) *WorkPool {
+	if workers < 1 {
+		workers = 1
+	}
+	p := &WorkPool{workers: workers, active: make(map[int]RunningTask)}
+	p.ctx, p.cancel = context.WithCancel(ctx)
+	p.idle = sync.NewCond(&p.mu)
+	p.queue = newScheduler(func() {
+		p.mu.Lock()
+		p.queue.fired()
+		p.dispatchLocked()
+		p.mu.Unlock()
+	})
+	go func() {
+		<-p.ctx.Done()
+		p.mu.Lock()
+		p.pending -= p.queue.clear()
+		p.broadcastIdleLocked()
+		p.mu.Unlock()
+	}()
+	return p
+}
+
+// Context is the context handed to every task.
+func (p *WorkPool) Context() context.Context { return p.ctx }
+
+// Cancel stops the run, see NewWorkPool.
+func (p *WorkPool) Cancel() { p.cancel() }
+
+// UseLimiter makes every task wait on l, keyed by its Key, before it runs.
+// The wait happens inside a worker slot, so it also holds back concurrency.
+func (p *WorkPool) UseLimiter(l Limiter) {
+	p.mu.Lock()
+	p.limiter = l
+	p.mu.Unlock()
+}
+
+// UseRetry retries failed tasks according to rp unless a task has its own
+// policy. A task waiting for its backoff goes back to the scheduler instead
+// of holding a worker, and runs ahead of fresh tasks once it is due.
+func (p *WorkPool) UseRetry(rp *RetryPolicy) {
+	p.mu.Lock()
+	p.retry = rp
+	p.mu.Unlock()
+}
+
+// SetTaskTimeout bounds every attempt of a task to d unless the task has its
+// own Timeout. The limit is enforced through the task's context, so Run has
+// to watch ctx for it to take effect.
+func (p *WorkPool) SetTaskTimeout(d time.Duration) {
+	p.mu.Lock()
+	p.timeout = d
+	p.mu.Unlock()
+}
+
+// OnResult registers fn to be called with the result of every task. Hooks
+// run on the worker goroutine before Wait can return.
+func (p *WorkPool) OnResult(fn func(TaskResult)) {
+	p.mu.Lock()
+	p.hooks = append(p.hooks, fn)
+	p.mu.Unlock()
+}
+
+// SetMaxPanics cancels the run once n tasks have panicked; the run's Cause
+// is then ErrTooManyPanics. Zero, the default, never aborts. Panics are
+// always recovered and reported as a *PanicError in the task's error.
+func (p *WorkPool) SetMaxPanics(n int) {
+	p.mu.Lock()
+	p.maxPanics = n
+	p.mu.Unlock()
+}
+
+// Resize changes how many tasks may run at once. Shrinking never interrupts
+// running tasks; the pool just starts fewer until it is below n.
+func (p *WorkPool) Resize(n int) {
+	if n < 1 {
+		n = 1
+	}
+	p.mu.Lock()
+	p.workers = n
+	p.dispatchLocked()
+	p.mu.Unlock()
+}
+
+// Workers returns the current concurrency limit.
+func (p *WorkPool) Workers() int {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	return p.workers
+}
+
+// Running returns how many tasks are running right now.
+func (p *WorkPool) Running() int {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	return p.running
+}
+
+// Queued returns how many tasks are due and waiting for a worker.
+func (p *WorkPool) Queued() int {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	return p.queue.ready.Len()
+}
+
+// Delayed returns how many tasks are scheduled for later.
+func (p *WorkPool) Delayed() int {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	return p.queue.delayed.Len()
+}
+
+// Skipped returns how many tasks were dropped because they already
+// completed in an earlier run.
+func (p *WorkPool) Skipped() int {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	return p.skipped
+}
+
+// Submit queues fn and returns its task ID. It never blocks.
+func (p *WorkPool) Submit(fn func(ctx context.Context) error) int {
+	return p.SubmitTask(&Task{Run: fn})
+}
+
+// SubmitAfter queues fn to start no earlier than d from now.
+func (p *WorkPool) SubmitAfter(d time.Duration, fn func(ctx context.Context) error) int {
+	return p.SubmitTask(&Task{Run: fn, At: time.Now().Add(d)})
+}
+
+// SubmitTask queues t, assigning its ID. Tasks submitted after the run was
+// cancelled or drained, or whose Key the checkpoint lists as done, are
+// dropped.
+func (p *WorkPool) SubmitTask(t *Task) int {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	p.nextID++
+	t.ID = p.nextID
+	if p.ctx.Err() != nil || p.stopped {
+		return t.ID
+	}
+	if p.checkpoint != nil && t.Key != "" && p.checkpoint.Done(t.Key) {
+		p.skipped++
+		return t.ID
+	}
+	p.queue.push(t, time.Now())
+	p.pending++
+	p.dispatchLocked()
+	return t.ID
+}
+
+// Drain stops the run gently: queued tasks are dropped, new ones refused,
+// and running tasks get up to timeout to finish before their context is
+// cancelled. Like WaitTimeout it reports whether the timeout was hit; the
+// tasks still running then are listed by Abandoned, no longer count as
+// pending and their late results are ignored, so Wait returns even if they
+// never do. Wait then returns a *PoolError with Cause ErrShutdown.
+func (p *WorkPool) Drain(timeout time.Duration) bool {
+	p.mu.Lock()
+	p.stopped = true
+	if p.abort == nil {
+		p.abort = ErrShutdown
+	}
+	p.pending -= p.queue.clear()
+	p.broadcastIdleLocked()
+	p.mu.Unlock()
+
+	idle := make(chan struct{})
+	go func() {
+		p.mu.Lock()
+		for p.pending > 0 {
+			p.idle.Wait()
+		}
+		p.mu.Unlock()
+		close(idle)
+	}()
+	timer := time.NewTimer(timeout)
+	defer timer.Stop()
+	select {
+	case <-idle:
+		return false
+	case <-timer.C:
+		p.mu.Lock()
+		p.abandoned = p.runningLocked()
+		for _, r := range p.abandoned {
+			delete(p.active, r.ID)
+		}
+		p.running -= len(p.abandoned)
+		p.pending -= len(p.abandoned)
+		p.broadcastIdleLocked()
+		p.mu.Unlock()
+		p.cancel()
+		return true
+	}
+}
+
+// Wait blocks until every submitted task has finished, then releases the
+// pool's context. It returns a *PoolError when a task failed or the run was
+// cancelled, nil otherwise.
+func (p *WorkPool) Wait() error {
+	p.mu.Lock()
+	for p.pending > 0 {
+		p.idle.Wait()
+	}
+	cause := p.ctx.Err()
+	if p.abort != nil {
+		cause = p.abort
+	}
+	errs, abandoned := p.errs, p.abandoned
+	p.mu.Unlock()
+	p.cancel()
+
+	if cause == nil && len(errs) == 0 {
+		return nil
+	}
+	sort.Slice(errs, func(i, j int) bool { return errs[i].ID < errs[j].ID })
+	return &PoolError{Tasks: errs, Cause: cause, Abandoned: abandoned}
+}
+
+func (p *WorkPool) dispatchLocked() {
+	if p.ctx.Err() != nil || p.stopped {
+		return
+	}
+	now := time.Now()
+	p.queue.promote(now)
+	for p.running < p.workers {
+		t := p.queue.next()
+		if t == nil {
+			break
+		}
+		// registered before the goroutine starts so Drain sees it as running
+		p.running++
+		p.active[t.ID] = RunningTask{ID: t.ID, Key: t.Key, Start: now}
+		go p.run(t)
+	}
+	p.queue.arm(now)
+}
+
+func (p *WorkPool) run(t *Task) {
+	p.mu.Lock()
+	limiter, retry, timeout, hooks := p.limiter, p.retry, p.timeout, p.hooks
+	r, live := p.active[t.ID]
+	p.mu.Unlock()
+	if !live {
+		return // abandoned by Drain before it started
+	}
+	if t.attempts == 0 {
+		t.start = r.Start
+	}
+	if t.Retry != nil {
+		retry = t.Retry
+	}
+	if t.Timeout > 0 {
+		timeout = t.Timeout
+	}
+
+	t.attempts++
+	err := p.attempt(t, limiter, timeout)
+	var delay time.Duration
+	requeue := false
+	if p.ctx.Err() == nil {
+		delay, requeue = retry.retryDelay(t.attempts, err)
+	}
+	p.mu.Lock()
+	_, live = p.active[t.ID]
+	delete(p.active, t.ID)
+	if live && requeue && !p.stopped && p.ctx.Err() == nil {
+		// free the slot; the task stays pending while it waits on the scheduler
+		now := time.Now()
+		t.At = now.Add(delay)
+		p.running--
+		p.queue.push(t, now)
+		p.dispatchLocked()
+		p.mu.Unlock()
+		return
+	}
+	p.mu.Unlock()
+	if !live {
+		return // abandoned by Drain, which already stopped counting it
+	}
+	res := TaskResult{ID: t.ID, Key: t.Key, Err: err, Attempts: t.attempts, Start: t.start, Duration: time.Since(t.start)}
+	for _, hook := range hooks {
+		if err := Safe(func() error { hook(res); return nil }); err != nil {
+			log.Printf("workpool: result hook for %v: %v\n%s", t, err, err.(*PanicError).Stack)
+		}
+	}
+
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	p.running--
+	p.pending--
+	if res.Err != nil {
+		p.errs = append(p.errs, &TaskError{ID: t.ID, Key: t.Key, Err: res.Err, Attempts: res.Attempts})
+	}
+	var pe *PanicError
+	if errors.As(res.Err, &pe) {
+		p.panics++
+		if p.maxPanics > 0 && p.panics >= p.maxPanics && p.abort == nil {
+			p.abort = ErrTooManyPanics
+			p.cancel()
+		}
+	}
+	p.dispatchLocked()
+	p.broadcastIdleLocked()
+}
+
+// attempt runs t once, recovering a panic into a *PanicError.
+func (p *WorkPool) attempt(t *Task, limiter Limiter, timeout time.Duration) error {
+	ctx := p.ctx
+	if limiter != nil {
+		if err := limiter.Wait(ctx, t.Key); err != nil {
+			return err
+		}
+	}
+	if timeout <= 0 {
+		return Safe(func() error { return t.Run(ctx) })
+	}
+	ctx, cancel := context.WithTimeout(ctx, timeout)
+	defer cancel()
+	err := Safe(func() error { return t.Run(ctx) })
+	if err != nil && ctx.Err() == context.DeadlineExceeded && p.ctx.Err() == nil {
+		err = fmt.Errorf("timed out after %v: %w", timeout, err)
+	}
+	return err
+}
+
+func (p *WorkPool) broadcastIdleLocked() {
+	if p.pending == 0 {
+		p.idle.Broadcast()
+	}
+}
@@ -0,0 +1,159 @@
+package tools
+
+import (
+	"context"
+	"errors"
+	"sync"
+	"testing"
+	"time"
+)
+
+func TestWorkPoolSubmitWait(t *testing.T) {
+	p := NewWorkPool(context.Background(), 4)
+	var mu sync.Mutex
+	running, peak, ran := 0, 0, 0
+	refused := errors.New("refused")
+	for i := 0; i < 40; i++ {
+		i := i
+		p.Submit(func(ctx context.Context) error {
+			mu.Lock()
+			running++
+			ran++
+			if running > peak {
+				peak = running
+			}
+			mu.Unlock()
+			time.Sleep(time.Millisecond)
+			mu.Lock()
+			running--
+			mu.Unlock()
+			if i == 30 || i == 7 {
+				return refused
+			}
+			return nil
+		})
+	}
+	err := p.Wait()
+	if ran != 40 || peak > 4 {
+		t.Fatalf("ran %d tasks, %d at once", ran, peak)
+	}
+	var pe *PoolError
+	if !errors.As(err, &pe) || pe.Cause != nil || len(pe.Tasks) != 2 {
+		t.Fatalf("Wait() = %v", err)
+	}
+	if pe.Tasks[0].ID != 8 || pe.Tasks[1].ID != 31 || !errors.Is(pe.Tasks[0], refused) {
+		t.Fatalf("failed tasks = %v", pe.Tasks)
+	}
+	if err := NewWorkPool(context.Background(), 2).Wait(); err != nil {
+		t.Fatalf("Wait() without tasks = %v", err)
+	}
+}
+
+func TestWorkPoolCancel(t *testing.T) {
+	p := NewWorkPool(context.Background(), 1)
+	started := make(chan struct{})
+	p.Submit(func(ctx context.Context) error {
+		close(started)
+		<-ctx.Done()
+		return ctx.Err()
+	})
+	ran := 0
+	for i := 0; i < 5; i++ {
+		p.Submit(func(ctx context.Context) error { ran++; return nil })
+	}
+	<-started
+	if p.Queued() != 5 {
+		t.Fatalf("Queued() = %d", p.Queued())
+	}
+	p.Cancel()
+	err := p.Wait()
+	if !errors.Is(err, context.Canceled) || ran != 0 {
+		t.Fatalf("Wait() = %v after %d queued tasks ran", err, ran)
+	}
+	p.Submit(func(ctx context.Context) error { ran++; return nil })
+	if p.Queued() != 0 || ran != 0 {
+		t.Fatal("task accepted after Cancel")
+	}
+}
+
+func TestWorkPoolDrainAbandonsStuckTasks(t *testing.T) {
+	p := NewWorkPool(context.Background(), 2)
+	stuck := make(chan struct{})
+	defer close(stuck)
+	late := false
+	p.OnResult(func(res TaskResult) {
+		if res.Key == "stuck" {
+			late = true
+		}
+	})
+	p.SubmitTask(&Task{Key: "stuck", Run: func(ctx context.Context) error {
+		<-stuck // ignores ctx
+		return nil
+	}})
+	p.SubmitTask(&Task{Key: "quick", Run: func(ctx context.Context) error { return nil }})
+	p.SubmitTask(&Task{Key: "queued", At: time.Now().Add(time.Hour), Run: func(ctx context.Context) error { return nil }})
+
+	time.Sleep(20 * time.Millisecond)
+	if !p.Drain(50 * time.Millisecond) {
+		t.Fatal("Drain did not time out")
+	}
+	if got := p.Abandoned(); len(got) != 1 || got[0].Key != "stuck" {
+		t.Fatalf("Abandoned() = %v", got)
+	}
+
+	done := make(chan error, 1)
+	go func() { done <- p.Wait() }()
+	select {
+	case err := <-done:
+		var pe *PoolError
+		if !errors.As(err, &pe) || !errors.Is(err, ErrShutdown) || len(pe.Abandoned) != 1 {
+			t.Fatalf("Wait() = %v", err)
+		}
+	case <-time.After(time.Second):
+		t.Fatal("Wait blocked on an abandoned task")
+	}
+	if p.Running() != 0 || late {
+		t.Fatalf("Running() = %d, late result reported: %v", p.Running(), late)
+	}
+}
+
+func TestWorkPoolDrainWaitsForRunningTasks(t *testing.T) {
+	p := NewWorkPool(context.Background(), 1)
+	p.Submit(func(ctx context.Context) error {
+		time.Sleep(30 * time.Millisecond)
+		return ctx.Err()
+	})
+	p.Submit(func(ctx context.Context) error { return nil })
+	time.Sleep(5 * time.Millisecond)
+	if p.Drain(time.Second) {
+		t.Fatal("Drain timed out")
+	}
+	if id := p.Submit(func(ctx context.Context) error { return nil }); p.Queued() != 0 {
+		t.Fatalf("task %d accepted after Drain", id)
+	}
+	err := p.Wait()
+	var pe *PoolError
+	if !errors.As(err, &pe) || pe.Cause != ErrShutdown || len(pe.Tasks) != 0 || len(pe.Abandoned) != 0 {
+		t.Fatalf("Wait() = %v", err)
+	}
+}
+
+func TestWorkPoolDrainAbandonsJustDispatchedTask(t *testing.T) {
+	for i := 0; i < 50; i++ {
+		p := NewWorkPool(context.Background(), 1)
+		stuck := make(chan struct{})
+		p.Submit(func(ctx context.Context) error {
+			<-stuck // ignores ctx
+			return nil
+		})
+		p.Drain(0)
+		done := make(chan error, 1)
+		go func() { done <- p.Wait() }()
+		select {
+		case <-done:
+		case <-time.After(time.Second):
+			t.Fatalf("run %d: Wait blocked on a task dispatched just before Drain", i)
+		}
+		close(stuck)
+	}
+}